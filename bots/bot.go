@@ -8,7 +8,9 @@ import (
 	"emoji-generator/processing"
 	"emoji-generator/progress"
 	"emoji-generator/queue"
+	"emoji-generator/retry"
 	"emoji-generator/types"
+	"errors"
 	"fmt"
 	"github.com/cavaliergopher/grab/v3"
 	"log/slog"
@@ -19,6 +21,7 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/time/rate"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
@@ -35,6 +38,14 @@ var (
 type UserBot interface {
 	SendMessageWithEmojis(ctx context.Context, chatID string, width int, packLink string, command string, emojis []types.EmojiMeta, replyTo int) error
 	SendMessage(ctx context.Context, chatID string, msg bot.SendMessageParams) error
+
+	// Личные сообщения userbot, для задач db.JobKindUserbot
+	SendEmojisInDM(ctx context.Context, userID int64, replyTo int, width int, packLink string, emojis []types.EmojiMeta) error
+	SendTextInDM(ctx context.Context, userID int64, replyTo int, text string) error
+	UpdateProgressMessage(ctx context.Context, chatID int64, msgID int, text string)
+	DeleteProgressMessage(ctx context.Context, chatID int64, msgID int)
+	// DownloadSource скачивает исходный файл команды userbot для /redo
+	DownloadSource(ctx context.Context, source string, workingDir string) (string, error)
 }
 
 type DripBot struct {
@@ -46,6 +57,13 @@ type DripBot struct {
 	stickerQueue     *queue.StickerQueue
 	messagesToDelete sync.Map
 	progressManager  *progress.Manager
+	jobsNotify       chan struct{}
+	runningJobs      sync.Map // id задачи -> context.CancelCauseFunc
+	uploadWorkers    int      // сколько файлов одной задачи загружается параллельно
+
+	spacerMu    sync.Mutex
+	spacerEmoji types.EmojiMeta // прозрачный разделитель из служебного набора, см. spacer
+	spacerOwner int64           // владелец служебного набора, SPACER_OWNER_ID
 }
 
 func NewDripBot(token string, userBot UserBot) (*DripBot, error) {
@@ -53,9 +71,16 @@ func NewDripBot(token string, userBot UserBot) (*DripBot, error) {
 	c := httpclient.NewClient(rl)
 
 	dbot := &DripBot{
-		userBot:      userBot,
-		token:        token,
-		stickerQueue: queue.New(),
+		userBot:       userBot,
+		token:         token,
+		stickerQueue:  queue.New(envInt("STICKER_QUEUE_PACKS", queue.DefaultLimit)),
+		jobsNotify:    make(chan struct{}, 1),
+		uploadWorkers: envInt("UPLOAD_WORKERS", defaultUploadWorkers),
+		spacerOwner:   int64(envInt("SPACER_OWNER_ID", 0)),
+	}
+
+	if dbot.spacerOwner == 0 {
+		slog.Warn("SPACER_OWNER_ID is not set, the spacer set will be owned by the first user who needs it")
 	}
 
 	b, err := bot.New(token,
@@ -76,6 +101,12 @@ func NewDripBot(token string, userBot UserBot) (*DripBot, error) {
 
 	tgbotApi.StopReceivingUpdates()
 
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, compositionCallbackPrefix, bot.MatchTypePrefix, func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		dbot.wg.Add(1)
+		defer dbot.wg.Done()
+		dbot.handleCompositionCallback(ctx, b, update)
+	})
+
 	dbot.bot = b
 	dbot.tgbotApi = tgbotApi
 	dbot.progressManager = progress.NewManager(b)
@@ -84,6 +115,7 @@ func NewDripBot(token string, userBot UserBot) (*DripBot, error) {
 }
 
 func (d *DripBot) Start(ctx context.Context) {
+	d.startJobWorkers(ctx)
 	d.bot.Start(ctx)
 }
 
@@ -102,6 +134,20 @@ func (d *DripBot) Shutdown(ctx context.Context) {
 	}
 }
 
+// envInt читает положительное целое из переменной окружения или возвращает значение по умолчанию
+func envInt(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		slog.Warn("invalid env value, using default", slog.String("name", name), slog.String("value", raw), slog.Int("default", def))
+		return def
+	}
+	return n
+}
+
 func (d *DripBot) BotUserName() string {
 	return d.tgbotApi.Self.UserName
 }
@@ -126,7 +172,13 @@ func (d *DripBot) handler(ctx context.Context, b *bot.Bot, update *models.Update
 		}
 
 		// Проверяем, является ли сообщение командой
-		if strings.HasPrefix(update.Message.Text, "/emoji") {
+		if strings.HasPrefix(update.Message.Text, "/cancel") {
+			d.handleCancelCommand(ctx, b, update)
+		} else if strings.HasPrefix(update.Message.Text, "/resend") {
+			d.handleResendCommand(ctx, b, update)
+		} else if strings.HasPrefix(update.Message.Text, "/redo") {
+			d.handleRedoCommand(ctx, b, update)
+		} else if strings.HasPrefix(update.Message.Text, "/emoji") {
 			d.handleEmojiCommand(ctx, b, update)
 		} else if update.Message.Text == "/emoji" {
 			d.handleEmojiCommand(ctx, b, update)
@@ -142,7 +194,10 @@ func (d *DripBot) handler(ctx context.Context, b *bot.Bot, update *models.Update
 	}
 
 	if update.Message.Chat.Type == models.ChatTypePrivate {
-		if strings.Contains(update.Message.Text, "start") {
+		if strings.HasPrefix(update.Message.Text, "/cancel") {
+			d.handleCancelCommand(ctx, b, update)
+			return
+		} else if strings.Contains(update.Message.Text, "start") {
 			d.handleStartCommand(ctx, b, update)
 			return
 		} else if strings.Contains(update.Message.Text, "info") {
@@ -161,6 +216,9 @@ func (d *DripBot) handler(ctx context.Context, b *bot.Bot, update *models.Update
 const (
 	defaultStickerFormat = "video"
 	defaultEmojiIcon     = "⭐️"
+
+	// defaultUploadWorkers сколько файлов одной задачи загружается параллельно, см. UPLOAD_WORKERS
+	defaultUploadWorkers = 4
 )
 
 func (d *DripBot) SendInitMessage(chatID int64, msgID int) {
@@ -289,40 +347,53 @@ func (d *DripBot) createBlankDatabaseRecord(ctx context.Context, botName string,
 	return nil
 }
 
-func (d *DripBot) prepareWorkingEnvironment(ctx context.Context, update *models.Update, args *types.EmojiCommand) error {
+func (d *DripBot) prepareWorkingEnvironment(ctx context.Context, args *types.EmojiCommand, fileID string, mimeType string) error {
 	if err := os.MkdirAll(args.WorkingDir, 0755); err != nil {
 		return fmt.Errorf("failed to create working directory: %w", err)
 	}
 
-	fileName, err := d.downloadFile(ctx, update.Message, args)
+	// Файлы команд userbot бот скачать не может, их заново скачивает userbot
+	if _, _, ok := types.ParseUserbotSource(fileID); ok {
+		fileName, err := d.userBot.DownloadSource(ctx, fileID, args.WorkingDir)
+		if err != nil {
+			return fmt.Errorf("%w: %w", types.ErrGetFileFromTelegram, err)
+		}
+		args.DownloadedFile = fileName
+		args.File = &models.File{FileID: fileID}
+		args.MimeType = mimeType
+		return nil
+	}
+
+	fileName, err := d.downloadFile(ctx, args, fileID, mimeType)
 	if err != nil {
 		return err
 	}
 	args.DownloadedFile = fileName
+	args.MimeType = mimeType
 	return nil
 }
 
 func (d *DripBot) handleDownloadError(ctx context.Context, update *models.Update, err error) {
 	slog.Error("Failed to download file", slog.String("err", err.Error()))
-	var message string
-	switch err {
-	case types.ErrFileNotProvided:
-		message = "Нужен файл для создания эмодзи"
-	case types.ErrFileOfInvalidType:
-		message = "Неподдерживаемый тип файла. Поддерживаются: GIF, JPEG, PNG, WebP, MP4, WebM, MPEG"
-	case types.ErrGetFileFromTelegram:
-		message = "Не удалось получить файл из Telegram"
-	case types.ErrFileDownloadFailed:
-		message = "Ошибка при загрузке файла"
+	d.sendErrorMessage(ctx, update.Message.Chat.ID, update.Message.ID, update.Message.MessageThreadID, downloadErrorMessage(err))
+}
+
+func downloadErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, types.ErrFileNotProvided):
+		return "Нужен файл для создания эмодзи"
+	case errors.Is(err, types.ErrFileOfInvalidType):
+		return "Неподдерживаемый тип файла. Поддерживаются: GIF, JPEG, PNG, WebP, MP4, WebM, MPEG"
+	case errors.Is(err, types.ErrGetFileFromTelegram):
+		return "Не удалось получить файл из Telegram"
 	default:
-		message = "Ошибка при загрузке файла"
+		return "Ошибка при загрузке файла"
 	}
-	d.sendErrorMessage(ctx, update.Message.Chat.ID, update.Message.ID, update.Message.MessageThreadID, message)
 }
 
-func (d *DripBot) downloadFile(ctx context.Context, m *models.Message, args *types.EmojiCommand) (string, error) {
+// sourceFile находит исходный файл в сообщении или в сообщении, на которое оно отвечает
+func sourceFile(m *models.Message) (string, string, error) {
 	var fileID string
-	var fileExt string
 	var mimeType string
 
 	var exist bool
@@ -340,7 +411,7 @@ func (d *DripBot) downloadFile(ctx context.Context, m *models.Message, args *typ
 			fileID = m.Document.FileID
 			mimeType = m.Document.MimeType
 		} else {
-			return "", types.ErrFileOfInvalidType
+			return "", "", types.ErrFileOfInvalidType
 		}
 		exist = true
 	} else if m.ReplyToMessage != nil {
@@ -365,65 +436,66 @@ func (d *DripBot) downloadFile(ctx context.Context, m *models.Message, args *typ
 	}
 
 	if exist == false {
-		return "", types.ErrFileNotProvided
+		return "", "", types.ErrFileNotProvided
 	}
 
-	file, err := d.bot.GetFile(ctx, &bot.GetFileParams{FileID: fileID})
-	if err != nil {
-		return "", fmt.Errorf("%w: %w", types.ErrGetFileFromTelegram, err)
+	if _, err := fileExtension(mimeType); err != nil {
+		return "", "", err
 	}
-	args.File = file
 
+	return fileID, mimeType, nil
+}
+
+func fileExtension(mimeType string) (string, error) {
 	switch mimeType {
 	case "image/gif":
-		fileExt = ".gif"
+		return ".gif", nil
 	case "image/jpeg":
-		fileExt = ".jpg"
+		return ".jpg", nil
 	case "image/png":
-		fileExt = ".png"
+		return ".png", nil
 	case "image/webp":
-		fileExt = ".webp"
+		return ".webp", nil
 	case "video/mp4":
-		fileExt = ".mp4"
+		return ".mp4", nil
 	case "video/webm":
-		fileExt = ".webm"
+		return ".webm", nil
 	case "video/mpeg":
-		fileExt = ".mpeg"
+		return ".mpeg", nil
 	default:
 		return "", types.ErrFileOfInvalidType
 	}
+}
 
-	fileURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", d.token, file.FilePath)
-	resp, err := grab.Get(args.WorkingDir+"/saved"+fileExt, fileURL)
+// downloadFile скачивает исходный файл по его file ID в рабочую директорию
+func (d *DripBot) downloadFile(ctx context.Context, args *types.EmojiCommand, fileID string, mimeType string) (string, error) {
+	fileExt, err := fileExtension(mimeType)
 	if err != nil {
-		return "", fmt.Errorf("%w: %w", types.ErrFileDownloadFailed, err)
+		return "", err
 	}
 
-	return resp.Filename, nil
-}
-
-// Функция для обработки ошибок с повторными попытками
-func (d *DripBot) handleTelegramError(err error) (int, error) {
-	if err == nil {
-		return 0, nil
+	file, err := d.bot.GetFile(ctx, &bot.GetFileParams{FileID: fileID})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", types.ErrGetFileFromTelegram, err)
 	}
+	args.File = file
 
-	if strings.Contains(err.Error(), "retry_after") {
-		// Извлекаем время ожидания из ошибки
-		parts := strings.Split(err.Error(), "retry_after ")
-		slog.Debug("handleTelegramError", slog.String("err", err.Error()), slog.String("parts", strings.Join(parts, " /// ")))
-		if len(parts) >= 2 {
-			if waitTime, parseErr := strconv.Atoi(strings.TrimSpace(parts[1])); parseErr == nil {
-				return waitTime + 5, nil
-			}
-		}
+	fileURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", d.token, file.FilePath)
+	req, err := grab.NewRequest(args.WorkingDir+"/saved"+fileExt, fileURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", types.ErrFileDownloadFailed, err)
+	}
+	resp := grab.DefaultClient.Do(req.WithContext(ctx))
+	if err := resp.Err(); err != nil {
+		return "", fmt.Errorf("%w: %w", types.ErrFileDownloadFailed, err)
 	}
-	return 0, err
+
+	return resp.Filename, nil
 }
 
 func (d *DripBot) uploadSticker(ctx context.Context, userID int64, filename string, data []byte) (string, error) {
-	for {
-		newSticker, err := d.bot.UploadStickerFile(ctx, &bot.UploadStickerFileParams{
+	newSticker, err := retry.Do(ctx, retry.Upload, func(ctx context.Context) (*models.File, error) {
+		return d.bot.UploadStickerFile(ctx, &bot.UploadStickerFileParams{
 			UserID: userID,
 			Sticker: &models.InputFileUpload{
 				Filename: filename,
@@ -431,140 +503,242 @@ func (d *DripBot) uploadSticker(ctx context.Context, userID int64, filename stri
 			},
 			StickerFormat: defaultStickerFormat,
 		})
+	})
+	if err != nil {
+		slog.Debug("upload sticker FAILED",
+			slog.String("file", filename),
+			slog.String("err", err.Error()))
+		return "", fmt.Errorf("upload sticker: %w", err)
+	}
 
+	return newSticker.FileID, nil
+}
+
+// uploadTile загружает один тайл. Если Telegram отклоняет его с STICKER_VIDEO_BIG,
+// перекодируется только этот тайл, уже загруженные файлы остаются как есть
+func (d *DripBot) uploadTile(ctx context.Context, args *types.EmojiCommand, emojiFiles []string, position int) (string, error) {
+	for attempt := 1; ; attempt++ {
+		fileData, err := os.ReadFile(emojiFiles[position])
 		if err != nil {
-			slog.Debug("upload sticker FAILED",
-				slog.String("file", filename),
-				slog.String("err", err.Error()))
-		} else {
+			return "", fmt.Errorf("open emoji file: %w", err)
 		}
 
-		if waitTime, err := d.handleTelegramError(err); err != nil {
-			return "", fmt.Errorf("upload sticker: %w", err)
-		} else if waitTime > 0 {
-			slog.Info("waiting before retry", "seconds", waitTime)
-			time.Sleep(time.Duration(waitTime) * time.Second)
-			continue
+		fileID, err := d.uploadSticker(ctx, args.UserID, emojiFiles[position], fileData)
+		if err == nil {
+			return fileID, nil
+		}
+		if !isStickerTooBig(err) || attempt > processing.MaxReencodeAttempts {
+			return "", err
 		}
 
-		return newSticker.FileID, nil
+		slog.Debug("re-encoding oversized tile",
+			slog.String("file", emojiFiles[position]),
+			slog.Int("attempt", attempt))
+		if err := processing.ShrinkTile(ctx, args, emojiFiles, position); err != nil {
+			return "", err
+		}
 	}
 }
 
-func (d *DripBot) AddEmojis(ctx context.Context, args *types.EmojiCommand, emojiFiles []string) (*models.StickerSet, [][]types.EmojiMeta, error) {
+// checkTiles готовит тайлы к загрузке и возвращает, какие из них полностью прозрачны
+func (d *DripBot) checkTiles(ctx context.Context, args *types.EmojiCommand, emojiFiles []string) ([]bool, error) {
 	if err := processing.ValidateEmojiFiles(emojiFiles); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	// Пытаемся получить доступ к обработке пака
-	canProcess, waitCh := d.stickerQueue.Acquire(args.PackLink)
-	if !canProcess {
-		// Если нельзя обрабатывать сейчас - ждем своей очереди
-		slog.Debug("В ОЧЕРЕДИ", slog.String("pack_link", args.PackLink))
-		select {
-		case <-ctx.Done():
-			d.stickerQueue.Release(args.PackLink)
-			return nil, nil, ctx.Err()
-		case <-waitCh:
-			slog.Debug("ОЧЕРЕДЬ ПРИШЛА, НАЧИНАЕТСЯ ОБРАБОТКА", slog.String("pack_link", args.PackLink))
-		}
+	// Перекодируем только те тайлы, что не проходят по размеру
+	if err := processing.ShrinkOversizedTiles(ctx, args, emojiFiles); err != nil {
+		return nil, err
 	}
-	defer d.stickerQueue.Release(args.PackLink)
 
-	var set *models.StickerSet
-	if !args.NewSet {
-		var err error
-		set, err = d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{
-			Name: args.PackLink,
-		})
-		if err != nil {
-			return nil, nil, fmt.Errorf("get sticker set: %w", err)
+	// Не начинаем загрузку, пока каждый тайл не пройдет проверку требований Telegram
+	report, err := processing.ValidateTiles(ctx, emojiFiles)
+	if err != nil {
+		return nil, err
+	}
+	if err := report.Err(); err != nil {
+		slog.Error("emoji tiles validation failed",
+			slog.String("pack_link", args.PackLink),
+			slog.Int("failed", len(report.Failed())),
+			slog.Int("total", len(report.Tiles)))
+		return nil, err
+	}
+
+	// Полностью прозрачные тайлы займет разделитель, в пак они не загружаются
+	empty, err := processing.EmptyTiles(ctx, args, emojiFiles)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(empty, false) {
+		return nil, types.ErrNoVisibleTiles
+	}
+	return empty, nil
+}
+
+// waitPack ждет очереди на запись в пак команды. Запись в один пак строго
+// последовательна, разные паки идут параллельно. После записи нужно вызвать Release.
+func (d *DripBot) waitPack(ctx context.Context, args *types.EmojiCommand) (*queue.Ticket, error) {
+	ticket := d.stickerQueue.Join(args.PackLink)
+	err := ticket.WaitReport(ctx, func(status queue.Status) {
+		slog.Debug("В ОЧЕРЕДИ", slog.String("pack_link", args.PackLink), slog.Int("position", status.Position), slog.Duration("eta", status.ETA))
+		if args.QueueProgress != nil {
+			args.QueueProgress(status.Position, status.ETA)
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
+	return ticket, nil
+}
 
-	// Загружаем все файлы эмодзи и возвращаем их fileIDs и метаданные
-	emojiFileIDs, emojiMetaRows, err := d.uploadEmojiFiles(ctx, args, set, emojiFiles)
+func (d *DripBot) AddEmojis(ctx context.Context, args *types.EmojiCommand, emojiFiles []string) (*models.StickerSet, [][]types.EmojiMeta, error) {
+	empty, err := d.checkTiles(ctx, args, emojiFiles)
 	if err != nil {
 		return nil, nil, err
 	}
+	return d.addTiles(ctx, args, emojiFiles, empty)
+}
 
-	// Создаем набор стикеров
+// addTiles добавляет проверенные тайлы в пак команды и записывает композицию
+func (d *DripBot) addTiles(ctx context.Context, args *types.EmojiCommand, emojiFiles []string, empty []bool) (*models.StickerSet, [][]types.EmojiMeta, error) {
+	ticket, err := d.waitPack(ctx, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ticket.Release()
 
-	if args.NewSet {
-		set, err = d.createNewStickerSet(ctx, args, emojiFileIDs)
-	} else {
-		set, err = d.addToExistingStickerSet(ctx, args, set, emojiFileIDs)
+	// Ячейки, загруженные и добавленные прошлой попыткой задачи
+	uploaded := make(map[int]types.UploadedTile)
+	if args.Checkpoint != nil {
+		uploaded, err = args.Checkpoint.Load(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Раскладываем видимые тайлы по пакам семьи: что не помещается в пак,
+	// уходит в пак-продолжение
+	visible := 0
+	for _, e := range empty {
+		if !e {
+			visible++
+		}
 	}
+	parts, set, err := d.planPackParts(ctx, args, visible, uploaded)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// Загружаем все файлы эмодзи и возвращаем их fileIDs и метаданные
+	emojiFileIDs, emojiMetaRows, err := d.uploadEmojiFiles(ctx, args, emojiFiles, empty, uploaded)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if added := visible - countSlots(parts); added > 0 {
+		slog.Info("resuming sticker upload",
+			slog.String("pack_link", args.PackLink),
+			slog.Int("added", added),
+			slog.Int("total", visible))
+	}
+
+	// Создаем или дополняем наборы семьи
+	for _, part := range parts {
+		if err := d.writePackPart(ctx, args, part, emojiFileIDs); err != nil {
+			return nil, nil, err
+		}
+		if part.link == args.PackLink {
+			set = part.set
+		} else {
+			d.updateFamilyEmojiCount(ctx, part)
+		}
+	}
+
 	slog.Debug("addEmojis",
 		slog.Int("emojiFileIDS count", len(emojiFileIDs)),
 		slog.Int("width", args.Width),
-		slog.Int("transparent_spacing", types.DefaultWidth-args.Width),
+		slog.Int("packs", len(parts)),
 		slog.Int("stickers in set", len(set.Stickers)))
 
-	// Обновляем emojiMetaRows только для последних стикеров
-	idx := 0
-	if !args.NewSet {
-		idx = len(set.Stickers) - len(emojiFileIDs) - 1
+	// Тайлы каждой части - последние стикеры ее набора, разделитель уже знает свой DocumentID
+	documentIDs := make([]string, len(emojiFileIDs))
+	for _, part := range parts {
+		slots := part.allSlots()
+		offset := len(part.set.Stickers) - len(slots)
+		for k, slot := range slots {
+			documentIDs[slot] = part.set.Stickers[offset+k].CustomEmojiID
+		}
 	}
 
+	slot := 0
 	for i := range emojiMetaRows {
 		for j := range emojiMetaRows[i] {
-			emojiMetaRows[i][j].DocumentID = set.Stickers[idx].CustomEmojiID
-			idx++
+			if emojiMetaRows[i][j].FileID == "" || emojiMetaRows[i][j].Transparent {
+				continue
+			}
+			emojiMetaRows[i][j].DocumentID = documentIDs[slot]
+			slot++
 		}
 	}
 
+	d.saveComposition(ctx, args, emojiMetaRows)
+
 	return set, emojiMetaRows, nil
 }
 
-// createNewStickerSet создает новый набор стикеров
-func (d *DripBot) createNewStickerSet(ctx context.Context, args *types.EmojiCommand, emojiFileIDs []string) (*models.StickerSet, error) {
-	totalWithTransparent := len(emojiFileIDs)
-	if totalWithTransparent > types.MaxStickersTotal {
-		return nil, fmt.Errorf("общее количество стикеров (%d) с прозрачными превысит максимум (%d)", totalWithTransparent, types.MaxStickersTotal)
+// writePackPart создает набор части или дописывает в него ее ячейки
+func (d *DripBot) writePackPart(ctx context.Context, args *types.EmojiCommand, part *packPart, emojiFileIDs []string) error {
+	var err error
+	switch {
+	case part.set == nil:
+		part.set, err = d.createStickerSetWithBatches(ctx, args, part, emojiFileIDs)
+	case len(part.slots) > 0:
+		part.set, err = d.addToExistingStickerSet(ctx, args, part, emojiFileIDs)
 	}
-
-	return d.createStickerSetWithBatches(ctx, args, emojiFileIDs)
+	return err
 }
 
 // addToExistingStickerSet добавляет эмодзи в существующий набор
-func (d *DripBot) addToExistingStickerSet(ctx context.Context, args *types.EmojiCommand, stickerSet *models.StickerSet, emojiFileIDs []string) (*models.StickerSet, error) {
+// Добавляются только ячейки части, остальные уже есть в наборе.
+func (d *DripBot) addToExistingStickerSet(ctx context.Context, args *types.EmojiCommand, part *packPart, emojiFileIDs []string) (*models.StickerSet, error) {
 
 	// Проверяем, что не превысим лимит
-	if len(stickerSet.Stickers)+len(emojiFileIDs) > types.MaxStickersTotal {
+	if len(part.set.Stickers)+len(part.slots) > types.MaxStickersTotal {
 		return nil, fmt.Errorf(
 			"превышен лимит стикеров в наборе (%d + %d > %d)",
-			len(stickerSet.Stickers),
-			len(emojiFileIDs),
+			len(part.set.Stickers),
+			len(part.slots),
 			types.MaxStickersTotal,
 		)
 	}
 
 	// Добавляем стикеры батчами
-	err := d.addStickersToSet(ctx, args, emojiFileIDs)
+	err := d.addStickersToSet(ctx, args, part.link, emojiFileIDs, part.slots)
 	if err != nil {
 		return nil, fmt.Errorf("add stickers to set: %w", err)
 	}
 
 	return d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{
-		Name: args.PackLink,
+		Name: part.link,
 	})
 }
 
-var maxRetries = 5
-
-func (d *DripBot) addStickersToSet(ctx context.Context, args *types.EmojiCommand, emojiFileIDs []string) error {
-	for i := 0; i < len(emojiFileIDs); i++ {
-
-		var err error
-		for j := 1; j <= maxRetries; j++ {
-			_, err = d.bot.AddStickerToSet(ctx, &bot.AddStickerToSetParams{
+// addStickerPolicy повторяет и STICKERSET_INVALID: сразу после создания набор
+// бывает еще не виден методу addStickerToSet
+var addStickerPolicy = func() retry.Policy {
+	p := retry.AddSticker
+	p.RetryIf = isStickerSetInvalid
+	return p
+}()
+
+// addStickersToSet добавляет ячейки slots в набор packLink по порядку и отмечает
+// каждую добавленную в чекпоинте
+func (d *DripBot) addStickersToSet(ctx context.Context, args *types.EmojiCommand, packLink string, emojiFileIDs []string, slots []int) error {
+	for _, i := range slots {
+		err := retry.Exec(ctx, addStickerPolicy, func(ctx context.Context) error {
+			_, err := d.bot.AddStickerToSet(ctx, &bot.AddStickerToSetParams{
 				UserID: args.UserID,
-				Name:   args.PackLink,
+				Name:   packLink,
 				Sticker: models.InputSticker{
 					Sticker: &models.InputFileString{Data: emojiFileIDs[i]},
 					Format:  defaultStickerFormat,
@@ -573,40 +747,62 @@ func (d *DripBot) addStickersToSet(ctx context.Context, args *types.EmojiCommand
 					},
 				},
 			})
-			if err == nil {
-				//slog.Debug("add sticker to set SUCCESS",
-				//	slog.String("file_id", emojiFileIDs[i]),
-				//	slog.String("pack", args.PackLink),
-				//	slog.Int64("user_id", args.UserID),
-				//)
-
-				break
-			} else {
-				slog.Debug("error sending sticker", "err", err.Error())
-				time.Sleep(time.Second * 1)
-			}
-		}
-
+			return err
+		})
 		if err != nil {
+			slog.Debug("error sending sticker", "err", err.Error())
 			return err
 		}
+
+		d.saveAdded(ctx, args, packLink, i)
 	}
 
 	return nil
 }
 
-// createStickerSetWithBatches создает новый набор стикеров
-func (d *DripBot) createStickerSetWithBatches(ctx context.Context, args *types.EmojiCommand, emojiFileIDs []string) (*models.StickerSet, error) {
-	count := len(emojiFileIDs)
+// saveAdded отмечает ячейки добавленными в набор packLink. Ошибка не прерывает загрузку:
+// в худшем случае повторная попытка добавит стикер еще раз.
+func (d *DripBot) saveAdded(ctx context.Context, args *types.EmojiCommand, packLink string, slots ...int) {
+	if args.Checkpoint == nil {
+		return
+	}
+	if err := args.Checkpoint.SaveAdded(ctx, packLink, slots...); err != nil {
+		slog.Error("Failed to save upload checkpoint", slog.String("pack_link", packLink), slog.String("err", err.Error()))
+	}
+}
+
+// uploadSlot загружает ячейку композиции или берет ее file ID, сохраненный прошлой попыткой
+func (d *DripBot) uploadSlot(ctx context.Context, args *types.EmojiCommand, uploaded map[int]types.UploadedTile, slot int, filePath string, upload func() (string, error)) (string, error) {
+	// Номер ячейки мог достаться другому файлу, например после перекодирования, поэтому сверяем файл
+	if tile, ok := uploaded[slot]; ok && tile.FileID != "" && tile.FilePath == filePath {
+		return tile.FileID, nil
+	}
+
+	fileID, err := upload()
+	if err != nil {
+		return "", err
+	}
+
+	if args.Checkpoint != nil {
+		if err := args.Checkpoint.SaveUploaded(ctx, slot, filePath, fileID); err != nil {
+			slog.Error("Failed to save upload checkpoint", slog.String("pack_link", args.PackLink), slog.String("err", err.Error()))
+		}
+	}
+	return fileID, nil
+}
+
+// createStickerSetWithBatches создает новый набор стикеров части
+func (d *DripBot) createStickerSetWithBatches(ctx context.Context, args *types.EmojiCommand, part *packPart, emojiFileIDs []string) (*models.StickerSet, error) {
+	count := len(part.slots)
 	if count > types.MaxStickersInBatch {
 		count = types.MaxStickersInBatch
 	}
 
 	firstBatch := make([]models.InputSticker, count)
 
-	for i := 0; i < count; i++ {
+	for i, slot := range part.slots[:count] {
 		firstBatch[i] = models.InputSticker{
-			Sticker: &models.InputFileString{Data: emojiFileIDs[i]},
+			Sticker: &models.InputFileString{Data: emojiFileIDs[slot]},
 			Format:  defaultStickerFormat,
 			EmojiList: []string{
 				defaultEmojiIcon,
@@ -614,42 +810,49 @@ func (d *DripBot) createStickerSetWithBatches(ctx context.Context, args *types.E
 		}
 	}
 
+	// Отмечаем первый батч до создания: если процесс упадет сразу после него,
+	// повторная попытка не добавит эти стикеры второй раз. Отметки, которых нет
+	// в наборе, снимает planPackParts.
+	if args.Checkpoint != nil {
+		if err := args.Checkpoint.SaveAdded(ctx, part.link, part.slots[:count]...); err != nil {
+			return nil, err
+		}
+	}
+
 	_, err := d.bot.CreateNewStickerSet(ctx, &bot.CreateNewStickerSetParams{
 		UserID:      args.UserID,
-		Name:        args.PackLink,
-		Title:       args.SetName,
+		Name:        part.link,
+		Title:       part.title,
 		StickerType: "custom_emoji",
 		Stickers:    firstBatch,
 	})
-	if err != nil && !strings.Contains(err.Error(), "STICKER_VIDEO_NOWEBM") {
-		slog.Debug("new sticker set FAILED", slog.String("name", args.PackLink), slog.String("error", err.Error()))
+	if err != nil && !bot.IsDescription(err, descStickerVideoNoWebm) {
+		slog.Debug("new sticker set FAILED", slog.String("name", part.link), slog.String("error", err.Error()))
 		return nil, fmt.Errorf("create sticker set: %w", err)
-	} else if err != nil && strings.Contains(err.Error(), "STICKER_VIDEO_NOWEBM") {
+	} else if err != nil && bot.IsDescription(err, descStickerVideoNoWebm) {
 		count = 1
 		_, err := d.bot.CreateNewStickerSet(ctx, &bot.CreateNewStickerSetParams{
 			UserID:      args.UserID,
-			Name:        args.PackLink,
-			Title:       args.SetName,
+			Name:        part.link,
+			Title:       part.title,
 			StickerType: "custom_emoji",
-			Stickers:    firstBatch,
+			Stickers:    firstBatch[:count],
 		})
 		if err != nil {
-			slog.Debug("new sticker set FAILED", slog.String("name", args.PackLink), slog.String("error", err.Error()))
+			slog.Debug("new sticker set FAILED", slog.String("name", part.link), slog.String("error", err.Error()))
 			return nil, fmt.Errorf("create sticker set: %w", err)
 		}
 	}
 
-	emojiFileIDs = emojiFileIDs[count:]
-
 	// Добавляем оставшиеся стикеры по одному
-	err = d.addStickersToSet(ctx, args, emojiFileIDs)
+	err = d.addStickersToSet(ctx, args, part.link, emojiFileIDs, part.slots[count:])
 	if err != nil {
 		return nil, fmt.Errorf("add stickers to set: %w", err)
 	}
 
 	// Получаем финальное состояние набора
 	set, err := d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{
-		Name: args.PackLink,
+		Name: part.link,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("get sticker set: %w", err)
@@ -658,148 +861,120 @@ func (d *DripBot) createStickerSetWithBatches(ctx context.Context, args *types.E
 	return set, nil
 }
 
-// uploadEmojiFiles загружает все файлы эмодзи и возвращает их fileIDs и метаданные
-func (d *DripBot) uploadEmojiFiles(ctx context.Context, args *types.EmojiCommand, set *models.StickerSet, emojiFiles []string) ([]string, [][]types.EmojiMeta, error) {
+// uploadEmojiFiles загружает все файлы эмодзи и возвращает их fileIDs и метаданные.
+// Отступы узких композиций и пустые тайлы (empty) занимает общий прозрачный
+// разделитель бота, в пак загружаются только видимые тайлы. Ячейки из uploaded
+// повторно не загружаются, номер ячейки совпадает с индексом в возвращаемых fileIDs.
+func (d *DripBot) uploadEmojiFiles(ctx context.Context, args *types.EmojiCommand, emojiFiles []string, empty []bool, uploaded map[int]types.UploadedTile) ([]string, [][]types.EmojiMeta, error) {
 	slog.Debug("uploading emoji stickers", slog.Int("count", len(emojiFiles)))
 
-	totalEmojis := len(emojiFiles)
-	rows := (totalEmojis + args.Width - 1) / args.Width // Округляем вверх
-	emojiMetaRows := make([][]types.EmojiMeta, rows)
-
-	// Проверка на превышение максимального количества стикеров
-	totalStickers := len(emojiFiles)
-	if args.Width < types.DefaultWidth {
-		totalStickers += (types.DefaultWidth - args.Width) * rows
-	}
+	layout := gridLayout(args.Width, len(emojiFiles), empty)
+	emojiMetaRows := make([][]types.EmojiMeta, len(layout))
 
-	if set != nil {
-		if set.Stickers != nil {
-			totalStickers += len(set.Stickers)
+	emptyCount := 0
+	for _, e := range empty {
+		if e {
+			emptyCount++
 		}
 	}
 
-	if totalStickers > types.MaxStickersTotal {
-		return nil, nil, fmt.Errorf("будет превышено максимальное количество эмодзи в паке (%d из %d)", totalStickers, types.MaxStickersTotal)
-	}
-
-	// Подготавливаем прозрачный стикер только если он нужен
-	var transparentData []byte
-	var err error
-	if args.Width < types.DefaultWidth {
-		transparentData, err = processing.PrepareTransparentData(args.Width)
+	// Разделитель нужен узким композициям и композициям с пустыми тайлами
+	var spacer types.EmojiMeta
+	if args.Width < types.DefaultWidth || emptyCount > 0 {
+		var err error
+		spacer, err = d.spacer(ctx, args.UserID)
 		if err != nil {
 			return nil, nil, err
 		}
 	}
 
-	for i := range emojiMetaRows {
-		if args.Width < types.DefaultWidth {
-			emojiMetaRows[i] = make([]types.EmojiMeta, types.DefaultWidth) // Инициализируем каждый ряд с полной шириной
-		} else {
-			emojiMetaRows[i] = make([]types.EmojiMeta, args.Width) // Инициализируем каждый ряд с полной шириной
+	var cells []gridCell
+	for row, layoutRow := range layout {
+		emojiMetaRows[row] = make([]types.EmojiMeta, len(layoutRow))
+		for pos, tile := range layoutRow {
+			switch tile {
+			case cellSpacer:
+				emojiMetaRows[row][pos] = spacer
+			case cellBlank:
+			default:
+				cells = append(cells, gridCell{row: row, pos: pos, tile: tile})
+			}
 		}
 	}
 
-	// Сначала загружаем все эмодзи и заполняем метаданные
-	for i, emojiFile := range emojiFiles {
-		fileData, err := os.ReadFile(emojiFile)
-		if err != nil {
-			return nil, nil, fmt.Errorf("open emoji file: %w", err)
-		}
-
-		fileID, err := d.uploadSticker(ctx, args.UserID, emojiFile, fileData)
-		if err != nil {
-			return nil, nil, err
-		} else {
-			//slog.Debug("upload sticker SUCCESS",
-			//	slog.String("file", emojiFile),
-			//	slog.String("pack", args.PackLink),
-			//	slog.Int64("user_id", args.UserID),
-			//	slog.Bool("transparent", false),
-			//)
-		}
-
-		// Вычисляем позицию в сетке
-		row := i / args.Width
-		col := i % args.Width
+	// Загрузки не зависят друг от друга, поэтому идут параллельно. Общий лимит
+	// запросов к Bot API соблюдает http-клиент бота.
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(d.uploadWorkers)
+	for slot, cell := range cells {
+		g.Go(func() error {
+			emojiFile := emojiFiles[cell.tile]
+			fileID, err := d.uploadSlot(gctx, args, uploaded, slot, emojiFile, func() (string, error) {
+				return d.uploadTile(gctx, args, emojiFiles, cell.tile)
+			})
+			if err != nil {
+				return err
+			}
+			// Каждая горутина пишет только в свою ячейку
+			emojiMetaRows[cell.row][cell.pos] = types.EmojiMeta{FileID: fileID, FileName: emojiFile}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
 
-		// Вычисляем отступы для центрирования
-		totalPadding := types.DefaultWidth - args.Width
-		leftPadding := totalPadding / 2
-		if totalPadding > 0 && totalPadding%2 != 0 {
-			// Для нечетного количества отступов, слева меньше на 1
-			leftPadding = (totalPadding - 1) / 2
-		}
+	// Собираем fileIDs тайлов в порядке ячеек
+	emojiFileIDs := make([]string, len(cells))
+	for i, cell := range cells {
+		emojiFileIDs[i] = emojiMetaRows[cell.row][cell.pos].FileID
+	}
 
-		// Загружаем прозрачные эмодзи слева только если нужно
-		if args.Width < types.DefaultWidth {
-			for j := 0; j < leftPadding; j++ {
-				if emojiMetaRows[row][j].FileID == "" {
-					transparentFileID, err := d.uploadSticker(ctx, args.UserID, "transparent.webm", transparentData)
-					if err != nil {
-						return nil, nil, fmt.Errorf("upload transparent sticker: %w", err)
-					} else {
-						//slog.Debug("upload sticker SUCCESS",
-						//	slog.String("file", emojiFile),
-						//	slog.String("pack", args.PackLink),
-						//	slog.Int64("user_id", args.UserID),
-						//	slog.Bool("transparent", true),
-						//)
-					}
-					emojiMetaRows[row][j] = types.EmojiMeta{
-						FileID:      transparentFileID,
-						FileName:    "transparent.webm",
-						Transparent: true,
-					}
-				}
-			}
-		}
+	return emojiFileIDs, emojiMetaRows, nil
+}
 
-		// Записываем метаданные эмодзи
-		pos := col
-		if args.Width < types.DefaultWidth {
-			pos = col + leftPadding
-		}
-		emojiMetaRows[row][pos] = types.EmojiMeta{
-			FileID:      fileID,
-			FileName:    emojiFile,
-			Transparent: false,
-		}
+// gridCell ячейка сетки композиции с видимым тайлом номер tile
+type gridCell struct {
+	row, pos int
+	tile     int
+}
 
-		// Загружаем прозрачные эмодзи справа только если нужно
-		if args.Width < types.DefaultWidth {
-			for j := col + leftPadding + 1; j < types.DefaultWidth; j++ {
-				if emojiMetaRows[row][j].FileID == "" {
-					transparentFileID, err := d.uploadSticker(ctx, args.UserID, "transparent.webm", transparentData)
-					if err != nil {
-						return nil, nil, fmt.Errorf("upload transparent sticker: %w", err)
-					} else {
-						//slog.Debug("upload sticker SUCCESS",
-						//	slog.String("file", emojiFile),
-						//	slog.String("pack", args.PackLink),
-						//	slog.Int64("user_id", args.UserID),
-						//	slog.Bool("transparent", true),
-						//)
-					}
-					emojiMetaRows[row][j] = types.EmojiMeta{
-						FileID:      transparentFileID,
-						FileName:    "transparent.webm",
-						Transparent: true,
-					}
-				}
-			}
-		}
-	}
+// Ячейки сетки без тайла, см. gridLayout
+const (
+	cellSpacer = -1 // разделитель
+	cellBlank  = -2 // хвост последнего ряда широкой композиции остается пустым
+)
 
-	// Теперь собираем emojiFileIDs в правильном порядке
-	emojiFileIDs := make([]string, 0, rows*types.DefaultWidth)
-	for i := range emojiMetaRows {
-		for j := range emojiMetaRows[i] {
-			if emojiMetaRows[i][j].FileID != "" {
-				emojiFileIDs = append(emojiFileIDs, emojiMetaRows[i][j].FileID)
+// gridLayout раскладывает total тайлов композиции шириной width по рядам: в ячейке
+// номер видимого тайла, cellSpacer или cellBlank. При узкой композиции тайлы
+// центрируются, а остальные ячейки, как и пустые тайлы (empty), занимает разделитель.
+func gridLayout(width, total int, empty []bool) [][]int {
+	rows := (total + width - 1) / width // Округляем вверх
+	rowWidth := max(width, types.DefaultWidth)
+
+	leftPadding := 0
+	if width < types.DefaultWidth {
+		// Для нечетного количества отступов слева меньше на 1
+		leftPadding = (types.DefaultWidth - width) / 2
+	}
+
+	layout := make([][]int, rows)
+	for row := range layout {
+		layout[row] = make([]int, rowWidth) // Каждый ряд полной ширины
+		for pos := range rowWidth {
+			col := pos - leftPadding
+			i := row*width + col
+			switch {
+			case col >= 0 && col < width && i < total && !empty[i]:
+				layout[row][pos] = i
+			case col >= 0 && col < width && i < total:
+				layout[row][pos] = cellSpacer
+			case width < types.DefaultWidth:
+				layout[row][pos] = cellSpacer
+			default:
+				layout[row][pos] = cellBlank
 			}
 		}
 	}
-
-	return emojiFileIDs, emojiMetaRows, nil
+	return layout
 }
@@ -0,0 +1,108 @@
+package bots
+
+import (
+	"context"
+	"emoji-generator/db"
+	"emoji-generator/processing"
+	"emoji-generator/types"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func (d *DripBot) handleCancelCommand(ctx context.Context, b *bot.Bot, update *models.Update) {
+	canceled, err := CancelUserJobs(ctx, update.Message.From.ID)
+
+	var message string
+	switch {
+	case err != nil:
+		slog.Error("Failed to cancel jobs", slog.Int64("user_id", update.Message.From.ID), slog.String("err", err.Error()))
+		message = "Не удалось отменить генерацию. Попробуйте позже"
+	case canceled == 0:
+		message = "Нет активных генераций"
+	default:
+		message = "Генерация отменена"
+	}
+
+	if update.Message.Chat.Type == models.ChatTypePrivate {
+		d.sendMessageByBot(ctx, update.Message.Chat.ID, update.Message.ID, message, nil)
+		return
+	}
+	d.sendErrorMessage(ctx, update.Message.Chat.ID, update.Message.ID, update.Message.MessageThreadID, message)
+}
+
+// CancelUserJobs отменяет задачи пользователя во всех ботах: ожидающие снимаются
+// с очереди, у выполняющихся отменяется контекст, и воркер откатывает их сам.
+// Возвращает количество отмененных задач.
+func CancelUserJobs(ctx context.Context, userID int64) (int, error) {
+	jobs, err := db.Postgres.CancelUserJobs(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, job := range jobs {
+		owner := Manager.GetBotByUsername(job.BotName)
+		if owner == nil {
+			continue
+		}
+
+		if job.Status == db.JobStatusRunning {
+			// Задачу может выполнять другой процесс, тогда он узнает об отмене при продлении аренды
+			owner.cancelRunningJob(job.ID)
+			continue
+		}
+
+		if job.ProgressMessageID != 0 && job.Kind != db.JobKindUserbot {
+			owner.progressManager.Restore(job.ChatID, job.ProgressMessageID, "")
+		}
+		owner.deleteJobProgress(ctx, &job)
+		removeJobDirectory(&job)
+	}
+
+	return len(jobs), nil
+}
+
+// removeJobDirectory удаляет рабочую директорию задачи, снятой с очереди. Задачи
+// userbot скачивают исходный файл туда еще при постановке.
+func removeJobDirectory(job *db.Job) {
+	var args types.EmojiCommand
+	if err := json.Unmarshal(job.Command, &args); err != nil {
+		slog.Error("Failed to decode job command", slog.Int64("job_id", job.ID), slog.String("err", err.Error()))
+		return
+	}
+	if args.WorkingDir == "" {
+		return
+	}
+	if err := processing.RemoveDirectory(args.WorkingDir); err != nil {
+		slog.Error("Failed to remove directory", slog.String("err", err.Error()), slog.String("dir", args.WorkingDir), slog.Int64("job_id", job.ID))
+	}
+}
+
+func (d *DripBot) cancelRunningJob(jobID int64) {
+	if cancel, ok := d.runningJobs.Load(jobID); ok {
+		cancel.(context.CancelCauseFunc)(types.ErrJobCanceled)
+	}
+}
+
+// RollbackPack удаляет наполовину созданный набор вместе с его паками-продолжениями
+// и помечает удаленными их записи в emoji_packs. Вызывается только для паков,
+// которые создавались отмененной задачей.
+func (d *DripBot) RollbackPack(ctx context.Context, packLink string) error {
+	for _, link := range familyLinks(ctx, packLink) {
+		_, err := d.bot.DeleteStickerSet(ctx, &bot.DeleteStickerSetParams{
+			Name: link,
+		})
+		if err != nil && !isStickerSetInvalid(err) {
+			return fmt.Errorf("delete sticker set: %w", err)
+		}
+
+		if err := db.Postgres.SetDeletedPack(ctx, link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package bots
+
+import (
+	"context"
+	"emoji-generator/db"
+	"emoji-generator/processing"
+	"emoji-generator/types"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// resendListLimit сколько последних композиций предлагает /resend
+const resendListLimit = 10
+
+// saveComposition записывает, какие эмодзи образуют собранную композицию. Ошибка
+// не прерывает генерацию: пак уже создан, теряется только запись в реестре.
+func (d *DripBot) saveComposition(ctx context.Context, args *types.EmojiCommand, emojiMetaRows [][]types.EmojiMeta) {
+	pack, err := db.Postgres.GetEmojiPackByPackLink(ctx, args.PackLink)
+	if err == nil {
+		_, err = db.Postgres.CreateComposition(ctx, db.NewComposition(pack.ID, args, emojiMetaRows))
+	}
+	if err != nil {
+		slog.Error("Failed to save composition",
+			slog.String("err", err.Error()),
+			slog.String("pack_link", args.PackLink),
+			slog.Int64("user_id", args.UserID))
+	}
+}
+
+// handleResendCommand показывает последние композиции пользователя, выбранную
+// юзербот заново публикует в текущий чат или топик
+func (d *DripBot) handleResendCommand(ctx context.Context, b *bot.Bot, update *models.Update) {
+	msg := update.Message
+	compositions, err := db.Postgres.GetUserCompositions(ctx, msg.From.ID, d.BotUserName(), resendListLimit)
+	if err != nil {
+		slog.Error("Failed to get compositions", slog.String("err", err.Error()), slog.Int64("user_id", msg.From.ID))
+		d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "Возникла внутреняя ошибка. Попробуйте позже")
+		return
+	}
+	if len(compositions) == 0 {
+		d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "У вас нет сохраненных композиций")
+		return
+	}
+
+	if err := d.sendCompositionChoice(ctx, msg, compositions, compositionActionResend); err != nil {
+		slog.Error("send compositions keyboard", slog.String("err", err.Error()))
+		d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "Не удалось отправить список композиций")
+	}
+}
+
+// Кнопки списка композиций: compositionCallbackPrefix + действие + ":" + id композиции.
+// Обработчик один на бота, см. handleCompositionCallback.
+const (
+	compositionCallbackPrefix = "composition:"
+	compositionActionResend   = "resend"
+	compositionActionRedo     = "redo"
+)
+
+// sendCompositionChoice отвечает на m списком композиций, выбранная обрабатывается
+// действием action в handleCompositionCallback
+func (d *DripBot) sendCompositionChoice(ctx context.Context, m *models.Message, compositions []*db.Composition, action string) error {
+	rows := make([][]models.InlineKeyboardButton, 0, len(compositions))
+	for _, c := range compositions {
+		rows = append(rows, []models.InlineKeyboardButton{{
+			Text:         compositionLabel(c),
+			CallbackData: compositionCallbackPrefix + action + ":" + strconv.FormatInt(c.ID, 10),
+		}})
+	}
+
+	_, err := d.bot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          m.Chat.ID,
+		MessageThreadID: m.MessageThreadID,
+		Text:            "Выберите композицию:",
+		ReplyParameters: &models.ReplyParameters{MessageID: m.ID, ChatID: m.Chat.ID},
+		ReplyMarkup:     &models.InlineKeyboardMarkup{InlineKeyboard: rows},
+	})
+	return err
+}
+
+// handleCompositionCallback обрабатывает выбор в списке композиций. Список отвечает
+// на команду, по ней восстанавливаются автор, чат и параметры. В группе кнопки видят
+// все участники, а композиция обрабатывается от имени автора команды, поэтому
+// нажатия других пользователей отклоняются и список не закрывают.
+func (d *DripBot) handleCompositionCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	action, rawID, _ := strings.Cut(strings.TrimPrefix(query.Data, compositionCallbackPrefix), ":")
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		d.answerCallback(ctx, query.ID, "")
+		return
+	}
+
+	list := query.Message.Message
+	if list == nil || list.ReplyToMessage == nil || list.ReplyToMessage.From == nil {
+		d.answerCallback(ctx, query.ID, "Список устарел, отправьте команду заново")
+		return
+	}
+	command := list.ReplyToMessage
+	if query.From.ID != command.From.ID {
+		d.answerCallback(ctx, query.ID, "Это список другого пользователя")
+		return
+	}
+
+	d.answerCallback(ctx, query.ID, "")
+	_, err = b.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: list.Chat.ID, MessageID: list.ID})
+	if err != nil {
+		slog.Error("delete compositions keyboard", slog.String("err", err.Error()))
+	}
+
+	switch action {
+	case compositionActionResend:
+		d.resendComposition(ctx, command.Chat.ID, command.MessageThreadID, command.ID, command.From.ID, id)
+	case compositionActionRedo:
+		d.redoSelectedComposition(ctx, command, id)
+	}
+}
+
+// answerCallback отвечает на нажатие кнопки, text показывается пользователю всплывающей подсказкой
+func (d *DripBot) answerCallback(ctx context.Context, queryID string, text string) {
+	_, err := d.bot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: queryID, Text: text})
+	if err != nil {
+		slog.Error("answer callback query", slog.String("err", err.Error()))
+	}
+}
+
+// resendComposition публикует сохраненную композицию той же сеткой, что при генерации
+func (d *DripBot) resendComposition(ctx context.Context, chatID int64, threadID int, replyTo int, userID int64, id int64) {
+	composition, err := db.Postgres.GetComposition(ctx, id, userID)
+	if err != nil {
+		slog.Error("Failed to get composition", slog.String("err", err.Error()), slog.Int64("composition_id", id), slog.Int64("user_id", userID))
+		d.sendErrorMessage(ctx, chatID, replyTo, threadID, "Композиция не найдена")
+		return
+	}
+
+	chat := fmt.Sprintf("%d", chatID)
+	if threadID != 0 {
+		chat = fmt.Sprintf("%s_%d", chat, threadID)
+	}
+
+	emojis := processing.GenerateEmojiMessage(composition.Rows())
+	err = d.userBot.SendMessageWithEmojis(ctx, chat, composition.Width, composition.PackLink, composition.Command, emojis, replyTo)
+	if err != nil {
+		slog.Error("Failed to resend composition", slog.String("err", err.Error()), slog.Int64("composition_id", id), slog.Int64("user_id", userID))
+		d.sendErrorMessage(ctx, chatID, replyTo, threadID, "Не удалось отправить композицию")
+	}
+}
+
+// deleteComposition удаляет стикеры композиции из паков семьи и помечает ее удаленной.
+// Композиции хранят id эмодзи, а не позиции в паке, поэтому сдвиг следующих
+// стикеров реестр не затрагивает. Пак блокируется как при генерации: незавершенная
+// задача восстанавливает добавленные ячейки по их позициям в конце набора.
+func (d *DripBot) deleteComposition(ctx context.Context, composition *db.Composition) error {
+	ticket, err := d.stickerQueue.Acquire(ctx, composition.PackLink)
+	if err != nil {
+		return err
+	}
+	defer ticket.Release()
+
+	if err := d.deleteCompositionStickers(ctx, composition); err != nil {
+		return err
+	}
+	return db.Postgres.SetDeletedComposition(ctx, composition.ID)
+}
+
+// deleteCompositionStickers удаляет стикеры композиции из паков семьи и обновляет
+// их количество эмодзи. Пак должен быть заблокирован вызывающим.
+func (d *DripBot) deleteCompositionStickers(ctx context.Context, composition *db.Composition) error {
+	// Разделители лежат в общем паке бота и остаются на месте
+	documentIDs := make(map[string]bool)
+	for i, documentID := range composition.DocumentIDs {
+		transparent := i < len(composition.Transparent) && composition.Transparent[i]
+		if documentID != "" && !transparent {
+			documentIDs[documentID] = true
+		}
+	}
+
+	family, err := db.Postgres.GetPackFamily(ctx, composition.PackLink)
+	if err != nil {
+		return err
+	}
+
+	for _, pack := range family {
+		set, err := d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{Name: *pack.PackLink})
+		if err != nil {
+			if isStickerSetInvalid(err) {
+				continue
+			}
+			return fmt.Errorf("get sticker set: %w", err)
+		}
+
+		removed := 0
+		for _, sticker := range set.Stickers {
+			if !documentIDs[sticker.CustomEmojiID] {
+				continue
+			}
+			if _, err = d.bot.DeleteStickerFromSet(ctx, &bot.DeleteStickerFromSetParams{Sticker: sticker.FileID}); err != nil {
+				break
+			}
+			removed++
+		}
+
+		if removed > 0 {
+			if err := db.Postgres.SetEmojiCount(ctx, pack.ID, len(set.Stickers)-removed); err != nil {
+				slog.Error("Failed to update emoji count", slog.String("err", err.Error()), slog.String("pack_link", *pack.PackLink))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("delete sticker from set: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// compositionLabel подпись кнопки композиции: когда создана и какого размера
+func compositionLabel(c *db.Composition) string {
+	rowWidth := max(c.Width, types.DefaultWidth)
+	rows := (len(c.DocumentIDs) + rowWidth - 1) / rowWidth
+	return fmt.Sprintf("%s · %d×%d", c.CreatedAt.Format("02.01.06 15:04"), c.Width, rows)
+}
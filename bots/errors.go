@@ -0,0 +1,60 @@
+package bots
+
+import (
+	"emoji-generator/retry"
+	"emoji-generator/types"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/go-telegram/bot"
+)
+
+// Описания ошибок Telegram, на которые реагирует генерация
+const (
+	descStickerVideoBig    = "STICKER_VIDEO_BIG"
+	descStickerVideoNoWebm = "STICKER_VIDEO_NOWEBM"
+	descStickerSetInvalid  = "STICKERSET_INVALID"
+	descPeerIDInvalid      = "PEER_ID_INVALID"
+	descUserNotFound       = "user not found"
+	descBotBlocked         = "bot was blocked by the user"
+)
+
+func isStickerTooBig(err error) bool {
+	return errors.Is(err, types.ErrTileTooBig) || bot.IsDescription(err, descStickerVideoBig)
+}
+
+func isStickerSetInvalid(err error) bool {
+	return bot.IsDescription(err, descStickerSetInvalid)
+}
+
+// isPeerUnavailable бот не может написать пользователю: тот не запускал бота или заблокировал его
+func isPeerUnavailable(err error) bool {
+	return bot.IsDescription(err, descPeerIDInvalid) ||
+		bot.IsDescription(err, descUserNotFound) ||
+		(errors.Is(err, bot.ErrorForbidden) && bot.IsDescription(err, descBotBlocked))
+}
+
+// UserMessage переводит ошибку генерации в сообщение для пользователя.
+// Если startNeeded, вместо сообщения нужно предложить пользователю запустить бота.
+func UserMessage(err error) (message string, startNeeded bool) {
+	var jobErr *jobError
+	if errors.As(err, &jobErr) {
+		return jobErr.Message, false
+	}
+
+	switch {
+	case isPeerUnavailable(err):
+		return "", true
+	case isStickerTooBig(err):
+		return "Не удалось уменьшить размер некоторых эмодзи. Попробуйте уменьшить ширину, либо измените файл.", false
+	case isStickerSetInvalid(err):
+		return "Не получилось создать некоторые эмодзи. Попробуйте еще раз, либо измените файл.", false
+	}
+
+	if wait, ok := retry.RetryAfter(err); ok && wait > 0 {
+		return fmt.Sprintf("Вы сможете создать пак только через %.0f минуты", math.Ceil(wait.Minutes())), false
+	}
+
+	return err.Error(), false
+}
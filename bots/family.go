@@ -0,0 +1,220 @@
+package bots
+
+import (
+	"context"
+	"emoji-generator/db"
+	"emoji-generator/types"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// packPart часть композиции, которая попадает в один пак семьи. Семья - корневой
+// пак и его паки-продолжения, которые создаются, когда в паке кончается место.
+type packPart struct {
+	link   string
+	title  string
+	record *db.EmojiPack
+	set    *models.StickerSet // nil, если набор еще не создан
+	slots  []int              // ячейки, которые нужно добавить
+	added  []int              // ячейки, добавленные в набор прошлой попыткой
+}
+
+// allSlots возвращает все ячейки части по порядку: они последние в ее наборе
+func (p *packPart) allSlots() []int {
+	slots := slices.Concat(p.added, p.slots)
+	slices.Sort(slots)
+	return slots
+}
+
+func countSlots(parts []*packPart) int {
+	n := 0
+	for _, part := range parts {
+		n += len(part.slots)
+	}
+	return n
+}
+
+// planPackParts раскладывает count видимых тайлов по пакам семьи args.PackLink:
+// сначала заполняются существующие паки, остаток уходит в новые паки-продолжения.
+// Ячейки, добавленные прошлой попыткой, остаются в своих паках. Возвращает части
+// с ячейками и текущий набор args.PackLink (nil, если он еще не создан).
+func (d *DripBot) planPackParts(ctx context.Context, args *types.EmojiCommand, count int, uploaded map[int]types.UploadedTile) ([]*packPart, *models.StickerSet, error) {
+	family, err := db.Postgres.GetPackFamily(ctx, args.PackLink)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sets := make(map[string]*models.StickerSet, len(family))
+	for _, pack := range family {
+		set, err := d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{Name: *pack.PackLink})
+		switch {
+		case err == nil:
+			sets[*pack.PackLink] = set
+		case isStickerSetInvalid(err) && (pack.ParentID != nil || args.NewSet):
+			// Набор еще не создан: новый пак или продолжение, создание которого прервалось
+		default:
+			return nil, nil, fmt.Errorf("get sticker set: %w", err)
+		}
+	}
+
+	added := make(map[string][]int)
+	for slot := range count {
+		if tile := uploaded[slot]; tile.Added {
+			link := tile.PackLink
+			if link == "" {
+				link = args.PackLink
+			}
+			added[link] = append(added[link], slot)
+		}
+	}
+	reconcileAdded(added, sets)
+
+	inSet := make(map[int]bool, count)
+	for _, slots := range added {
+		for _, slot := range slots {
+			inSet[slot] = true
+		}
+	}
+	pending := make([]int, 0, count)
+	for slot := range count {
+		if !inSet[slot] {
+			pending = append(pending, slot)
+		}
+	}
+
+	var parts []*packPart
+	var mainSet *models.StickerSet
+	title := args.SetName
+	for i, pack := range family {
+		part := &packPart{link: *pack.PackLink, record: pack, added: added[*pack.PackLink], set: sets[*pack.PackLink]}
+
+		// Продолжения называются так же, как корневой пак
+		if i == 0 && part.set != nil {
+			title = part.set.Title
+		} else if i == 0 && pack.PackName != "" {
+			title = pack.PackName
+		}
+		part.title = title
+
+		room := types.MaxStickersTotal
+		if part.set != nil {
+			room -= len(part.set.Stickers)
+		}
+		n := max(0, min(room, len(pending)))
+		part.slots, pending = pending[:n], pending[n:]
+
+		if part.link == args.PackLink {
+			mainSet = part.set
+		}
+		if len(part.slots) > 0 || len(part.added) > 0 {
+			parts = append(parts, part)
+		}
+	}
+
+	for index := len(family) + 1; len(pending) > 0; index++ {
+		record, err := d.createContinuationRecord(ctx, family[0], index)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		n := min(types.MaxStickersTotal, len(pending))
+		parts = append(parts, &packPart{
+			link:   *record.PackLink,
+			title:  title,
+			record: record,
+			slots:  pending[:n],
+		})
+		pending = pending[n:]
+
+		slog.Info("pack overflow, continuing in a new pack",
+			slog.String("pack_link", args.PackLink),
+			slog.String("continuation", *record.PackLink),
+			slog.Int("stickers", n))
+	}
+
+	return parts, mainSet, nil
+}
+
+// reconcileAdded сверяет ячейки, отмеченные добавленными, с наборами. Первый батч
+// отмечается до создания набора, поэтому если создание не прошло или набор создан
+// не целиком, отметки лишние: набор создан этой задачей, и его стикеры - первые
+// отмеченные ячейки. Лишние ячейки снимаются и будут добавлены заново.
+func reconcileAdded(added map[string][]int, sets map[string]*models.StickerSet) {
+	for link, slots := range added {
+		present := 0
+		if set, ok := sets[link]; ok {
+			present = len(set.Stickers)
+		}
+		if present < len(slots) {
+			slog.Info("reconciling checkpoint with sticker set",
+				slog.String("pack_link", link),
+				slog.Int("marked", len(slots)),
+				slog.Int("present", present))
+			added[link] = slots[:present]
+		}
+	}
+}
+
+// createContinuationRecord добавляет в семью root запись пака-продолжения с номером index.
+// Сам набор создается при записи части.
+func (d *DripBot) createContinuationRecord(ctx context.Context, root *db.EmojiPack, index int) (*db.EmojiPack, error) {
+	link, err := continuationLink(*root.PackLink, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.Postgres.CreateEmojiPack(ctx, &db.EmojiPack{
+		CreatorID:      root.CreatorID,
+		PackName:       root.PackName,
+		TelegramFileID: root.TelegramFileID,
+		PackLink:       &link,
+		InitialCommand: root.InitialCommand,
+		BotName:        root.BotName,
+		ParentID:       &root.ID,
+	})
+}
+
+// continuationLink имя пака-продолжения: номер вставляется перед обязательным
+// суффиксом _by_<бот>, например dt123_by_bot -> dt123_2_by_bot
+func continuationLink(rootLink string, index int) (string, error) {
+	i := strings.LastIndex(rootLink, "_by_")
+	if i < 0 {
+		return "", fmt.Errorf("pack link %s has no bot suffix", rootLink)
+	}
+
+	link := fmt.Sprintf("%s_%d%s", rootLink[:i], index, rootLink[i:])
+	if len(link) > types.TelegramPackLinkAndNameLength {
+		return "", fmt.Errorf("continuation link %s is longer than %d", link, types.TelegramPackLinkAndNameLength)
+	}
+	return link, nil
+}
+
+// updateFamilyEmojiCount обновляет количество эмодзи пака-продолжения.
+// Количество в паке команды обновляет вызывающий.
+func (d *DripBot) updateFamilyEmojiCount(ctx context.Context, part *packPart) {
+	if err := db.Postgres.SetEmojiCount(ctx, part.record.ID, len(part.set.Stickers)); err != nil {
+		slog.Error("Failed to update emoji count",
+			slog.String("err", err.Error()),
+			slog.String("pack_link", part.link))
+	}
+}
+
+// familyLinks возвращает ссылки всех паков семьи packLink. Если семью найти
+// не удалось, возвращается только сам пак.
+func familyLinks(ctx context.Context, packLink string) []string {
+	family, err := db.Postgres.GetPackFamily(ctx, packLink)
+	if err != nil {
+		return []string{packLink}
+	}
+
+	links := make([]string, 0, len(family))
+	for _, pack := range family {
+		links = append(links, *pack.PackLink)
+	}
+	return links
+}
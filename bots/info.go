@@ -18,13 +18,29 @@ func (d *DripBot) sendInfoMessage(ctx context.Context, chatID int64, replyTo int
 
 Параметры:
 • width=[N] или w=[N] - ширина нарезки (по умолчанию 8). Чем меньше ширина, тем крупнее эмодзи
+• h=[N] - число рядов (по умолчанию по пропорциям файла)
+• fit=[contain|cover|stretch|crop] - как вписать файл в сетку: contain - целиком с прозрачными полями (по умолчанию: без h= файл, как и раньше, режется на всю ширину, а остаток нижнего ряда прозрачный), cover - заполнить сетку с обрезкой, stretch - растянуть, crop - вырезать кусок без масштабирования
+• anchor=[center|top|bottom] - какая часть сохраняется при обрезке и куда прижимается файл при вписывании (по умолчанию top для contain, иначе center)
 • background=[цвет] или b=[цвет] - цвет фона, который будет вырезан из изображения. Поддерживаются:
   - HEX формат: b=[0x00FF00]
   - Названия: b=[black], b=[white], b=[pink], b=[green]
 • b_sim=[число] - порог схожести цвета с фоном (0-1, по умолчанию 0.1)
 • b_blend=[число] - использовать смешивание цветов для удаления фона (0-1, по умолчанию 0.1)
 • link=[ссылка] или l=[ссылка] - добавить эмодзи в существующий пак (должен быть создан вами)
-• iphone=[true] или i=[true] - оптимизация размера под iPhone`
+• iphone=[true] или i=[true] - оптимизация размера под iPhone
+• start=[сек] - с какой секунды брать видео (по умолчанию с начала)
+• auto=[true] - выбрать самый подвижный отрезок видео без склеек, start важнее
+• dur=[сек] - длительность эмодзи, до 3 секунд (по умолчанию 3)
+• fps=[N] - частота кадров, до 30 (по умолчанию 10)
+• speed=[число] - скорость проигрывания от 0.25 до 4
+• reverse=[true] - проигрывать в обратную сторону
+• loop=[boomerang] - проигрывать вперед и обратно
+• loop=[smooth] - плавный переход из конца в начало, без рывка на стыке
+• q=[high|balanced|small] - качество эмодзи: high - максимальное, balanced - по умолчанию, small - самые легкие файлы
+
+Команда /cancel отменяет текущую генерацию. Если пак создавался этой генерацией, он будет удален.
+Команда /resend в чате заново отправляет одну из ваших последних композиций.
+Команда /redo перегенерирует композицию с новыми параметрами, например /redo b=black b_sim=0.3. Отправьте ее ответом на сообщение с композицией или выберите композицию из списка.`
 
 	params := &bot.SendMessageParams{
 		ChatID: chatID,
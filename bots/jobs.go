@@ -0,0 +1,447 @@
+package bots
+
+import (
+	"context"
+	"database/sql"
+	"emoji-generator/db"
+	"emoji-generator/processing"
+	"emoji-generator/progress"
+	"emoji-generator/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// Этапы задачи генерации пака
+const (
+	jobStageDownload   = "download"
+	jobStageRecord     = "record"
+	jobStageProcessing = "processing"
+	jobStageUpload     = "upload"
+	jobStageCompose    = "compose"
+)
+
+var (
+	// jobLease через это время без продления аренды задачу может забрать другой воркер
+	jobLease          = 3 * time.Minute
+	jobHeartbeat      = time.Minute
+	jobPollInterval   = 5 * time.Second
+	jobMaxAttempts    = 3
+	defaultJobWorkers = 4
+)
+
+// jobError ошибка задачи с сообщением, которое увидит пользователь
+type jobError struct {
+	Message string
+	Err     error
+}
+
+func (e *jobError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *jobError) Unwrap() error {
+	return e.Err
+}
+
+// startJobWorkers запускает воркеры, которые забирают задачи бота из Postgres.
+// Задачи, прерванные остановкой или падением процесса, забираются повторно.
+func (d *DripBot) startJobWorkers(ctx context.Context) {
+	workers := envInt("JOB_WORKERS", defaultJobWorkers)
+	hostname, _ := os.Hostname()
+
+	for i := 0; i < workers; i++ {
+		workerID := fmt.Sprintf("%s:%d:%s:%d", hostname, os.Getpid(), d.BotUserName(), i)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.jobWorker(ctx, workerID)
+		}()
+	}
+
+	slog.Info("job workers started", slog.String("bot", d.BotUserName()), slog.Int("workers", workers))
+}
+
+func (d *DripBot) jobWorker(ctx context.Context, workerID string) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Забираем задачи, пока они есть, потом ждем уведомления или тика
+		for ctx.Err() == nil {
+			job, err := db.Postgres.ClaimJob(ctx, d.BotUserName(), workerID, jobLease, jobMaxAttempts)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Failed to claim job", slog.String("worker", workerID), slog.String("err", err.Error()))
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			d.runJob(ctx, workerID, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.jobsNotify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// notifyJobs будит свободный воркер после постановки задачи
+func (d *DripBot) notifyJobs() {
+	select {
+	case d.jobsNotify <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueEmojiJob сохраняет команду и исходный файл в очередь задач
+func (d *DripBot) enqueueEmojiJob(ctx context.Context, kind string, m *models.Message, args *types.EmojiCommand, fileID string, mimeType string, progressMsgID int) (*db.Job, error) {
+	return d.enqueueJob(ctx, args, &db.Job{
+		Kind:              kind,
+		FileID:            fileID,
+		MimeType:          mimeType,
+		ChatID:            m.Chat.ID,
+		ThreadID:          m.MessageThreadID,
+		ReplyTo:           m.ID,
+		ProgressMessageID: progressMsgID,
+	})
+}
+
+// EnqueueUserbotJob ставит в очередь команду из личных сообщений userbot. Файл
+// уже скачан в args.DownloadedFile, поэтому задачу выполнит воркер этого же хоста.
+// progressMsgID - сообщение userbot, прогресс обновляет он же.
+func (d *DripBot) EnqueueUserbotJob(ctx context.Context, args *types.EmojiCommand, chatID int64, replyTo int, progressMsgID int) (*db.Job, error) {
+	return d.enqueueJob(ctx, args, &db.Job{
+		Kind:              db.JobKindUserbot,
+		FileID:            args.File.FileID,
+		MimeType:          args.MimeType,
+		ChatID:            chatID,
+		ReplyTo:           replyTo,
+		ProgressMessageID: progressMsgID,
+	})
+}
+
+func (d *DripBot) enqueueJob(ctx context.Context, args *types.EmojiCommand, job *db.Job) (*db.Job, error) {
+	command, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("marshal emoji command: %w", err)
+	}
+	job.BotName = d.BotUserName()
+	job.Command = command
+	job.UserID = args.UserID
+
+	job, err = db.Postgres.EnqueueJob(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	d.notifyJobs()
+	return job, nil
+}
+
+func (d *DripBot) runJob(ctx context.Context, workerID string, job *db.Job) {
+	var args types.EmojiCommand
+	if err := json.Unmarshal(job.Command, &args); err != nil {
+		slog.Error("Failed to decode job command", slog.Int64("job_id", job.ID), slog.String("err", err.Error()))
+		d.finishJob(job, db.JobStatusFailed, err)
+		return
+	}
+
+	slog.LogAttrs(ctx, slog.LevelDebug, "job started",
+		slog.Int64("job_id", job.ID),
+		slog.String("worker", workerID),
+		slog.Int("attempt", job.Attempts),
+		slog.String("stage", job.Stage))
+
+	// Отмена через /cancel прерывает ffmpeg, ожидание очереди и загрузку
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	d.runningJobs.Store(job.ID, cancel)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		d.heartbeatJob(jobCtx, cancel, workerID, job.ID)
+	}()
+
+	if job.ProgressMessageID != 0 && job.Kind != db.JobKindUserbot {
+		d.progressManager.Restore(job.ChatID, job.ProgressMessageID, "")
+	}
+
+	// Пак создается этой задачей, значит при отмене его можно откатить целиком
+	newSet := args.NewSet
+	err := d.executeEmojiJob(jobCtx, job, &args)
+	canceled := errors.Is(context.Cause(jobCtx), types.ErrJobCanceled)
+	cancel(nil)
+	<-heartbeatDone
+	d.runningJobs.Delete(job.ID)
+
+	// Процесс останавливается: задача вернется в очередь и продолжится после рестарта
+	if err != nil && !canceled && ctx.Err() != nil {
+		slog.Info("job interrupted, requeueing", slog.Int64("job_id", job.ID), slog.String("stage", job.Stage))
+		requeueCtx, requeueCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer requeueCancel()
+		if err := db.Postgres.RequeueJob(requeueCtx, job.ID); err != nil {
+			slog.Error("Failed to requeue job", slog.Int64("job_id", job.ID), slog.String("err", err.Error()))
+		}
+		return
+	}
+
+	d.deleteJobProgress(ctx, job)
+	if args.WorkingDir != "" {
+		if err := processing.RemoveDirectory(args.WorkingDir); err != nil {
+			slog.Error("Failed to remove directory", slog.String("err", err.Error()), slog.String("dir", args.WorkingDir), slog.String("emojiPackLink", args.PackLink), slog.Int64("user_id", args.UserID))
+		}
+	}
+
+	if err != nil && canceled {
+		slog.Info("job canceled", slog.Int64("job_id", job.ID), slog.String("stage", job.Stage), slog.String("pack_link", args.PackLink))
+		if newSet {
+			rollbackCtx, rollbackCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer rollbackCancel()
+			if err := d.RollbackPack(rollbackCtx, args.PackLink); err != nil {
+				slog.Error("Failed to roll back canceled pack", slog.String("pack_link", args.PackLink), slog.String("err", err.Error()))
+			}
+		}
+		d.finishJob(job, db.JobStatusCanceled, types.ErrJobCanceled)
+		return
+	}
+
+	if err != nil {
+		d.finishJob(job, db.JobStatusFailed, err)
+		d.replyJobError(ctx, job, err)
+		return
+	}
+
+	d.finishJob(job, db.JobStatusDone, nil)
+}
+
+func (d *DripBot) heartbeatJob(ctx context.Context, cancel context.CancelCauseFunc, workerID string, jobID int64) {
+	ticker := time.NewTicker(jobHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			alive, err := db.Postgres.HeartbeatJob(ctx, jobID, workerID)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Failed to heartbeat job", slog.Int64("job_id", jobID), slog.String("err", err.Error()))
+				}
+				continue
+			}
+			if !alive {
+				// Задачу отменили из другого процесса
+				cancel(types.ErrJobCanceled)
+				return
+			}
+		}
+	}
+}
+
+func (d *DripBot) finishJob(job *db.Job, status string, jobErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.Postgres.FinishJob(ctx, job.ID, status, jobErr); err != nil {
+		slog.Error("Failed to finish job", slog.Int64("job_id", job.ID), slog.String("status", status), slog.String("err", err.Error()))
+	}
+}
+
+func (d *DripBot) setJobStage(ctx context.Context, job *db.Job, stage string, status string) {
+	job.Stage = stage
+	if err := db.Postgres.SetJobStage(ctx, job.ID, stage); err != nil {
+		slog.Error("Failed to set job stage", slog.Int64("job_id", job.ID), slog.String("stage", stage), slog.String("err", err.Error()))
+	}
+
+	if status != "" {
+		d.updateJobProgress(ctx, job, status)
+	}
+}
+
+// updateJobProgress обновляет сообщение о прогрессе задачи. У задач userbot это
+// его сообщение, бот редактировать его не может.
+func (d *DripBot) updateJobProgress(ctx context.Context, job *db.Job, status string) {
+	if job.ProgressMessageID == 0 {
+		return
+	}
+	if job.Kind == db.JobKindUserbot {
+		d.userBot.UpdateProgressMessage(ctx, job.ChatID, job.ProgressMessageID, status)
+		return
+	}
+	if err := d.updateProgressMessage(ctx, job.ChatID, job.ProgressMessageID, status); err != nil {
+		slog.Error("Failed to update progress message", slog.String("err", err.Error()))
+	}
+}
+
+func (d *DripBot) deleteJobProgress(ctx context.Context, job *db.Job) {
+	if job.ProgressMessageID == 0 {
+		return
+	}
+	if job.Kind == db.JobKindUserbot {
+		d.userBot.DeleteProgressMessage(ctx, job.ChatID, job.ProgressMessageID)
+		return
+	}
+	if err := d.deleteProgressMessage(ctx, job.ChatID, job.ProgressMessageID); err != nil {
+		slog.Error("Failed to delete progress message", slog.String("err", err.Error()))
+	}
+}
+
+// executeEmojiJob выполняет этапы задачи: загрузка файла, запись в базе,
+// обработка видео, загрузка эмодзи в пак и отправка результата
+func (d *DripBot) executeEmojiJob(ctx context.Context, job *db.Job, args *types.EmojiCommand) error {
+	d.setJobStage(ctx, job, jobStageDownload, "")
+	if job.Kind == db.JobKindUserbot {
+		// Файл скачан userbot при постановке задачи
+		if _, err := os.Stat(args.DownloadedFile); err != nil {
+			return &jobError{Message: "Исходный файл не найден, отправьте команду заново", Err: err}
+		}
+	} else if err := d.prepareWorkingEnvironment(ctx, args, job.FileID, job.MimeType); err != nil {
+		return &jobError{Message: downloadErrorMessage(err), Err: err}
+	}
+
+	d.setJobStage(ctx, job, jobStageRecord, "")
+	emojiPack, err := d.jobEmojiPack(ctx, job, args)
+	if err != nil {
+		return err
+	}
+
+	d.setJobStage(ctx, job, jobStageProcessing, "🎬 Обрабатываем видео...")
+	createdFiles, err := processing.ProcessVideo(ctx, args)
+	if err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "Ошибка при обработке видео", args.ToSlogAttributes(slog.String("err", err.Error()))...)
+		return &jobError{Message: fmt.Sprintf("Ошибка при обработке видео: %s", err.Error()), Err: err}
+	}
+
+	// Повторная попытка продолжит загрузку с последнего подтвержденного стикера
+	args.Checkpoint = db.JobCheckpoint{JobID: job.ID}
+
+	const uploadStatus = "✨ Создаем эмодзи..."
+	d.setJobStage(ctx, job, jobStageUpload, uploadStatus)
+	if job.ProgressMessageID != 0 {
+		args.QueueProgress = func(position int, eta time.Duration) {
+			status := uploadStatus
+			if position > 0 {
+				status = progress.QueueStatus(position, eta)
+			}
+			d.updateJobProgress(ctx, job, status)
+		}
+	}
+	var stickerSet *models.StickerSet
+	var emojiMetaRows [][]types.EmojiMeta
+	if args.Composition != 0 {
+		stickerSet, emojiMetaRows, err = d.redoComposition(ctx, args, createdFiles)
+	} else {
+		stickerSet, emojiMetaRows, err = d.AddEmojis(ctx, args, createdFiles)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Обновляем количество эмодзи в базе данных
+	if err := db.Postgres.SetEmojiCount(ctx, emojiPack.ID, len(stickerSet.Stickers)); err != nil {
+		slog.Error("Failed to update emoji count",
+			slog.String("err", err.Error()),
+			slog.String("pack_link", args.PackLink),
+			slog.Int64("user_id", args.UserID))
+	}
+
+	d.setJobStage(ctx, job, jobStageCompose, "🎨 Генерируем эмодзи-композицию...")
+	if job.Kind == db.JobKindDM {
+		d.sendMessageByBot(ctx, job.ChatID, job.ReplyTo, fmt.Sprintf("Ваш пак\n%s%s", "https://t.me/addemoji/"+args.PackLink, segmentNote(args)), nil)
+		return nil
+	}
+
+	// Создаем композицию эмодзи, используя метаданные из emojiMetaRows
+	selectedEmojis := processing.GenerateEmojiMessage(emojiMetaRows)
+	if job.Kind == db.JobKindUserbot {
+		if err := d.userBot.SendEmojisInDM(ctx, job.ChatID, job.ReplyTo, args.Width, args.PackLink, selectedEmojis); err != nil {
+			slog.Error("Failed to send message with emojis in DM", slog.String("err", err.Error()), slog.String("pack_link", args.PackLink), slog.Int64("user_id", args.UserID))
+			d.forgetSpacer(ctx, selectedEmojis)
+			d.replyUserbot(ctx, job, "Не удалось отправить сообщение c эмоджи композицией, но вот ваш пак: https://t.me/addemoji/"+args.PackLink)
+		}
+		return nil
+	}
+	err = d.userBot.SendMessageWithEmojis(ctx, jobChat(job), args.Width, args.PackLink, args.RawInitCommand+segmentNote(args), selectedEmojis, job.ReplyTo)
+	if err != nil {
+		slog.Error("Failed to send message with emojis", slog.String("err", err.Error()), slog.String("username", args.UserName), slog.Int64("user_id", args.UserID))
+		d.forgetSpacer(ctx, selectedEmojis)
+	}
+
+	return nil
+}
+
+// jobEmojiPack находит запись пака или создает ее для нового пака
+func (d *DripBot) jobEmojiPack(ctx context.Context, job *db.Job, args *types.EmojiCommand) (*db.EmojiPack, error) {
+	emojiPack, err := db.Postgres.GetEmojiPackByPackLink(ctx, args.PackLink)
+	if err != nil && (!args.NewSet || !errors.Is(err, sql.ErrNoRows)) {
+		slog.Error("Failed to get emoji pack", slog.String("err", err.Error()), slog.String("pack_link", args.PackLink))
+		return nil, &jobError{Message: "пак с подобной ссылкой не найден", Err: err}
+	}
+
+	if emojiPack == nil {
+		initialCommand := strings.TrimSpace(strings.TrimPrefix(args.RawInitCommand, "/emoji"))
+		emojiPack, err = d.createDatabaseRecord(ctx, args, initialCommand, job.BotName)
+		if err != nil {
+			slog.Error("Failed to log emoji command",
+				slog.String("err", err.Error()),
+				slog.String("pack_link", args.PackLink),
+				slog.Int64("user_id", args.UserID))
+			return nil, &jobError{Message: "Не удалось создать запись в базе данных", Err: err}
+		}
+	}
+
+	return emojiPack, nil
+}
+
+// segmentNote сообщает, какой отрезок видео выбран при auto=true
+func segmentNote(args *types.EmojiCommand) string {
+	if !args.Auto {
+		return ""
+	}
+	start, end := processing.Segment(args)
+	return fmt.Sprintf("\nОтрезок видео: %.1f–%.1f с", start, end)
+}
+
+func jobChat(job *db.Job) string {
+	if job.ThreadID != 0 {
+		return fmt.Sprintf("%d_%d", job.ChatID, job.ThreadID)
+	}
+	return fmt.Sprintf("%d", job.ChatID)
+}
+
+// replyJobError сообщает пользователю, почему задача не выполнена
+func (d *DripBot) replyJobError(ctx context.Context, job *db.Job, err error) {
+	message, startNeeded := UserMessage(err)
+	if startNeeded {
+		d.SendInitMessage(job.ChatID, job.ReplyTo)
+		return
+	}
+
+	switch job.Kind {
+	case db.JobKindDM:
+		d.sendMessageByBot(ctx, job.ChatID, job.ReplyTo, message, nil)
+	case db.JobKindUserbot:
+		d.replyUserbot(ctx, job, message)
+	default:
+		d.sendErrorMessage(ctx, job.ChatID, job.ReplyTo, job.ThreadID, message)
+	}
+}
+
+// replyUserbot отвечает на команду задачи db.JobKindUserbot от имени userbot
+func (d *DripBot) replyUserbot(ctx context.Context, job *db.Job, text string) {
+	if err := d.userBot.SendTextInDM(ctx, job.ChatID, job.ReplyTo, text); err != nil {
+		slog.Error("Failed to send message by userBot", slog.String("err", err.Error()), slog.Int64("job_id", job.ID))
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/go-telegram/ui/keyboard/inline"
 	"github.com/go-telegram/ui/keyboard/reply"
 	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/go-telegram/bot"
@@ -37,11 +39,19 @@ func (d *DripBot) onPackSelect(ctx context.Context, b *bot.Bot, update *models.U
 	kb := inline.New(d.bot).
 		Row().
 		Button("Мои паки", []byte("packs"), d.onRemovePacksSelect).
-		Button("Удалить пак", []byte(update.Message.Text), d.onPackDelete)
+		Button("Удалить пак", []byte(update.Message.Text), d.onPackDelete).
+		Row().
+		Button("Удалить композицию", []byte(update.Message.Text), d.onPackCompositionsSelect)
+
+	// Паки-продолжения показываются вместе с корневым паком
+	text := "Выбран пак:"
+	for _, link := range familyLinks(ctx, update.Message.Text) {
+		text += "\nt.me/addemoji/" + link
+	}
 
 	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID:      update.Message.Chat.ID,
-		Text:        "Выбран пак:\nt.me/addemoji/" + update.Message.Text,
+		Text:        text,
 		ReplyMarkup: kb,
 	})
 	if err != nil {
@@ -53,28 +63,36 @@ func (d *DripBot) onPackSelect(ctx context.Context, b *bot.Bot, update *models.U
 }
 
 func (d *DripBot) onPackDelete(ctx context.Context, b *bot.Bot, mes models.MaybeInaccessibleMessage, data []byte) {
-	err := db.Postgres.SetDeletedPack(ctx, string(data))
-	if err != nil {
-		slog.Error("delete emoji pack", slog.String("err", err.Error()), slog.String("name", string(data)), slog.String("username", mes.Message.Chat.Username), slog.Int64("user_id", mes.Message.From.ID))
-		d.sendMessageByBot(ctx, mes.Message.Chat.ID, 0, "Не удалось удалить пак. Попробуйте позже", nil)
-		return
-	}
+	// Пак удаляется вместе с паками-продолжениями, корневой - последним
+	links := familyLinks(ctx, string(data))
+	slices.Reverse(links)
 
-	_, err = d.bot.DeleteStickerSet(ctx, &bot.DeleteStickerSetParams{
-		Name: string(data),
-	})
-	if err != nil {
-		//err = db.Postgres.UnsetDeletedPack(ctx, string(data))
-		if strings.Contains(err.Error(), "STICKERSET_INVALID") && strings.Contains(string(data), d.tgbotApi.Self.UserName) {
-			d.sendMessageByBot(ctx, mes.Message.Chat.ID, 0, "Похоже пак уже удален.", d.startKeyboard(ctx))
-			err = db.Postgres.SetDeletedPack(ctx, string(data))
-			if err != nil {
-				slog.Error("set delete emoji pack", slog.String("err", err.Error()), slog.String("link", string(data)), slog.String("username", mes.Message.Chat.Username), slog.Int64("user_id", mes.Message.From.ID))
+	alreadyDeleted := false
+	for _, link := range links {
+		err := db.Postgres.SetDeletedPack(ctx, link)
+		if err != nil {
+			slog.Error("delete emoji pack", slog.String("err", err.Error()), slog.String("name", link), slog.String("username", mes.Message.Chat.Username), slog.Int64("user_id", mes.Message.From.ID))
+			d.sendMessageByBot(ctx, mes.Message.Chat.ID, 0, "Не удалось удалить пак. Попробуйте позже", nil)
+			return
+		}
+
+		_, err = d.bot.DeleteStickerSet(ctx, &bot.DeleteStickerSetParams{
+			Name: link,
+		})
+		if err != nil {
+			//err = db.Postgres.UnsetDeletedPack(ctx, link)
+			if isStickerSetInvalid(err) && strings.Contains(link, d.tgbotApi.Self.UserName) {
+				alreadyDeleted = link == string(data)
+				continue
 			}
+			slog.Error("delete sticker set", slog.String("err", err.Error()), slog.String("name", link), slog.String("username", mes.Message.Chat.Username), slog.Int64("user_id", mes.Message.From.ID))
+			d.sendMessageByBot(ctx, mes.Message.Chat.ID, 0, "Не удалось удалить пак. Попробуйте позже", nil)
 			return
 		}
-		slog.Error("delete sticker set", slog.String("err", err.Error()), slog.String("name", string(data)), slog.String("username", mes.Message.Chat.Username), slog.Int64("user_id", mes.Message.From.ID))
-		d.sendMessageByBot(ctx, mes.Message.Chat.ID, 0, "Не удалось удалить пак. Попробуйте позже", nil)
+	}
+
+	if alreadyDeleted {
+		d.sendMessageByBot(ctx, mes.Message.Chat.ID, 0, "Похоже пак уже удален.", d.startKeyboard(ctx))
 		return
 	}
 
@@ -84,7 +102,7 @@ func (d *DripBot) onPackDelete(ctx context.Context, b *bot.Bot, mes models.Maybe
 		ReplyMarkup: d.startKeyboard(ctx),
 	}
 
-	_, err = d.bot.SendMessage(ctx, params)
+	_, err := d.bot.SendMessage(ctx, params)
 	if err != nil {
 		slog.Error("send message", slog.String("err", err.Error()))
 		d.sendMessageByBot(ctx, mes.Message.Chat.ID, 0, "Пак удален.", nil)
@@ -92,6 +110,67 @@ func (d *DripBot) onPackDelete(ctx context.Context, b *bot.Bot, mes models.Maybe
 	return
 }
 
+// onPackCompositionsSelect показывает композиции пака, выбранная будет удалена из него
+func (d *DripBot) onPackCompositionsSelect(ctx context.Context, b *bot.Bot, mes models.MaybeInaccessibleMessage, data []byte) {
+	if mes.Message == nil {
+		return
+	}
+
+	compositions, err := db.Postgres.GetPackCompositions(ctx, string(data), mes.Message.Chat.ID)
+	if err != nil {
+		slog.Error("get pack compositions", slog.String("err", err.Error()), slog.String("name", string(data)), slog.Int64("user_id", mes.Message.Chat.ID))
+		d.sendErrorMessage(ctx, mes.Message.Chat.ID, 0, 0, "Возникла внутреняя ошибка. Попробуйте позже")
+		return
+	}
+	if len(compositions) == 0 {
+		d.sendMessageByBot(ctx, mes.Message.Chat.ID, 0, "В паке нет сохраненных композиций", nil)
+		return
+	}
+
+	kb := inline.New(d.bot)
+	for _, c := range compositions {
+		kb.Row().Button(compositionLabel(c), []byte(strconv.FormatInt(c.ID, 10)), d.onCompositionDelete)
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      mes.Message.Chat.ID,
+		Text:        "Выберите композицию для удаления:",
+		ReplyMarkup: kb,
+	})
+	if err != nil {
+		slog.Error("send compositions keyboard", slog.String("err", err.Error()))
+		d.sendErrorMessage(ctx, mes.Message.Chat.ID, 0, 0, "Не удалось отправить список композиций")
+	}
+}
+
+func (d *DripBot) onCompositionDelete(ctx context.Context, b *bot.Bot, mes models.MaybeInaccessibleMessage, data []byte) {
+	if mes.Message == nil {
+		return
+	}
+	chatID := mes.Message.Chat.ID
+
+	id, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return
+	}
+
+	// В личном чате id чата совпадает с id пользователя
+	composition, err := db.Postgres.GetComposition(ctx, id, chatID)
+	if err != nil {
+		slog.Error("get composition", slog.String("err", err.Error()), slog.Int64("composition_id", id), slog.Int64("user_id", chatID))
+		d.sendMessageByBot(ctx, chatID, 0, "Композиция не найдена. Возможно, она уже удалена", nil)
+		return
+	}
+
+	if err = d.deleteComposition(ctx, composition); err != nil {
+		slog.Error("delete composition", slog.String("err", err.Error()), slog.Int64("composition_id", id), slog.String("pack_link", composition.PackLink), slog.Int64("user_id", chatID))
+		d.sendMessageByBot(ctx, chatID, 0, "Не удалось удалить композицию. Попробуйте позже", nil)
+		return
+	}
+
+	d.sendMessageByBot(ctx, chatID, 0, "Композиция удалена", d.startKeyboard(ctx))
+}
+
 func (d *DripBot) onRemovePacksSelect(ctx context.Context, b *bot.Bot, mes models.MaybeInaccessibleMessage, data []byte) {
 	//fmt.Println(mes.Message.From.ID)
 	//j, _ := json.MarshalIndent(mes, "", "  ")
@@ -0,0 +1,356 @@
+package bots
+
+import (
+	"context"
+	"database/sql"
+	"emoji-generator/db"
+	"emoji-generator/processing"
+	"emoji-generator/retry"
+	"emoji-generator/types"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// handleRedoCommand перегенерирует композицию с новыми параметрами. Композиция
+// берется из сообщения, на которое отвечает /redo, иначе пользователь выбирает ее из списка.
+func (d *DripBot) handleRedoCommand(ctx context.Context, b *bot.Bot, update *models.Update) {
+	msg := update.Message
+	if msg.From == nil || msg.From.IsBot || msg.From.ID < 0 {
+		d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "Перегенерировать композицию можно только с личного аккаунта")
+		return
+	}
+
+	if msg.ReplyToMessage != nil {
+		composition, err := repliedComposition(ctx, msg.ReplyToMessage, msg.From.ID)
+		if err != nil {
+			slog.Error("Failed to find replied composition", slog.String("err", err.Error()), slog.Int64("user_id", msg.From.ID))
+			d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "Возникла внутреняя ошибка. Попробуйте позже")
+			return
+		}
+		if composition == nil {
+			d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "Ответьте на сообщение с вашей композицией или отправьте /redo без ответа")
+			return
+		}
+		d.enqueueRedo(ctx, msg, composition)
+		return
+	}
+
+	compositions, err := db.Postgres.GetUserCompositions(ctx, msg.From.ID, d.BotUserName(), resendListLimit)
+	if err != nil {
+		slog.Error("Failed to get compositions", slog.String("err", err.Error()), slog.Int64("user_id", msg.From.ID))
+		d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "Возникла внутреняя ошибка. Попробуйте позже")
+		return
+	}
+	if len(compositions) == 0 {
+		d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "У вас нет сохраненных композиций")
+		return
+	}
+
+	if err := d.sendCompositionChoice(ctx, msg, compositions, compositionActionRedo); err != nil {
+		slog.Error("send compositions keyboard", slog.String("err", err.Error()))
+		d.sendErrorMessage(ctx, msg.Chat.ID, msg.ID, msg.MessageThreadID, "Не удалось отправить список композиций")
+	}
+}
+
+// redoSelectedComposition перегенерирует композицию id, выбранную из списка на команду m
+func (d *DripBot) redoSelectedComposition(ctx context.Context, m *models.Message, id int64) {
+	composition, err := db.Postgres.GetComposition(ctx, id, m.From.ID)
+	if err != nil {
+		slog.Error("Failed to get composition", slog.String("err", err.Error()), slog.Int64("composition_id", id), slog.Int64("user_id", m.From.ID))
+		d.sendErrorMessage(ctx, m.Chat.ID, m.ID, m.MessageThreadID, "Композиция не найдена")
+		return
+	}
+	d.enqueueRedo(ctx, m, composition)
+}
+
+// repliedComposition находит композицию пользователя по кастомным эмодзи сообщения.
+// Возвращает nil, если ни один эмодзи не принадлежит его композициям.
+func repliedComposition(ctx context.Context, m *models.Message, userID int64) (*db.Composition, error) {
+	for _, entity := range m.Entities {
+		if entity.Type != models.MessageEntityTypeCustomEmoji {
+			continue
+		}
+
+		composition, err := db.Postgres.GetCompositionByDocumentID(ctx, entity.CustomEmojiID, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		return composition, err
+	}
+	return nil, nil
+}
+
+// enqueueRedo ставит перегенерацию композиции в очередь задач. Параметры исходной
+// команды дополняются параметрами /redo из m, пак остается прежним.
+func (d *DripBot) enqueueRedo(ctx context.Context, m *models.Message, composition *db.Composition) {
+	if composition.FileID == "" || composition.MimeType == "" {
+		d.sendErrorMessage(ctx, m.Chat.ID, m.ID, m.MessageThreadID, "Исходный файл этой композиции не сохранен, перегенерировать ее нельзя")
+		return
+	}
+
+	options := strings.TrimSpace(strings.TrimPrefix(m.Text, "/redo"))
+	base := processing.ExtractCommandArgs(composition.Command, "")
+	args, err := processing.ParseArgs(processing.MergeCommandArgs(base, options))
+	if err != nil {
+		slog.Error("Invalid arguments", slog.String("err", err.Error()))
+		d.sendErrorMessage(ctx, m.Chat.ID, m.ID, m.MessageThreadID, err.Error())
+		return
+	}
+
+	permissions, err := db.Postgres.Permissions(ctx, m.From.ID)
+	if err != nil {
+		slog.Error("Failed to get permissions", slog.String("err", err.Error()))
+		d.sendErrorMessage(ctx, m.Chat.ID, m.ID, m.MessageThreadID, "Возникла внутреняя ошибка. Попробуйте позже")
+		return
+	}
+	args.Permissions = permissions
+
+	processing.SetupEmojiCommand(args, m.From.ID, m.From.Username)
+	args.PackLink = composition.PackLink
+	args.NewSet = false
+	args.SetName = ""
+	args.Composition = composition.ID
+
+	var progressMsgID int
+	progress, err := d.sendProgressMessage(ctx, m.Chat.ID, m.ID, "⏳ Задача поставлена в очередь...")
+	if err != nil {
+		slog.Error("Failed to send initial progress message",
+			slog.String("err", err.Error()),
+			slog.Int64("user_id", args.UserID))
+	} else {
+		progressMsgID = progress.MessageID
+	}
+
+	if _, err := d.enqueueEmojiJob(ctx, db.JobKindChat, m, args, composition.FileID, composition.MimeType, progressMsgID); err != nil {
+		slog.Error("Failed to enqueue redo job", slog.String("err", err.Error()), slog.Int64("composition_id", composition.ID), slog.Int64("user_id", args.UserID))
+		if progressMsgID != 0 {
+			d.deleteProgressMessage(ctx, m.Chat.ID, progressMsgID)
+		}
+		d.sendErrorMessage(ctx, m.Chat.ID, m.ID, m.MessageThreadID, "Возникла внутреняя ошибка. Попробуйте позже")
+	}
+}
+
+// redoComposition заменяет стикеры композиции args.Composition новыми тайлами на
+// тех же позициях, так что сообщения с ней остаются рабочими. Если сетка изменилась,
+// новая композиция добавляется в пак, а старая удаляется.
+func (d *DripBot) redoComposition(ctx context.Context, args *types.EmojiCommand, emojiFiles []string) (*models.StickerSet, [][]types.EmojiMeta, error) {
+	composition, err := db.Postgres.GetComposition(ctx, args.Composition, args.UserID)
+	if err != nil {
+		return nil, nil, &jobError{Message: "Композиция не найдена. Возможно, она удалена", Err: err}
+	}
+
+	empty, err := d.checkTiles(ctx, args, emojiFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	layout := gridLayout(args.Width, len(emojiFiles), empty)
+	if args.Width == composition.Width && sameShape(composition, layout) {
+		return d.replaceComposition(ctx, args, emojiFiles, empty, composition, layout)
+	}
+
+	slog.Info("composition shape changed, re-adding",
+		slog.Int64("composition_id", composition.ID),
+		slog.String("pack_link", args.PackLink))
+
+	set, emojiMetaRows, err := d.addTiles(ctx, args, emojiFiles, empty)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Новая композиция уже в паке, поэтому ошибка удаления старой задачу не прерывает
+	if err := d.deleteComposition(ctx, composition); err != nil {
+		slog.Error("Failed to delete redone composition",
+			slog.String("err", err.Error()),
+			slog.Int64("composition_id", composition.ID),
+			slog.String("pack_link", args.PackLink))
+	}
+
+	// Набор мог измениться после удаления
+	if updated, err := d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{Name: args.PackLink}); err == nil {
+		set = updated
+	}
+	return set, emojiMetaRows, nil
+}
+
+// sameShape проверяет, что видимые тайлы новой сетки занимают те же ячейки, что
+// стикеры композиции
+func sameShape(composition *db.Composition, layout [][]int) bool {
+	cells := slices.Concat(layout...)
+	if len(cells) != len(composition.DocumentIDs) {
+		return false
+	}
+
+	for i, tile := range cells {
+		transparent := i < len(composition.Transparent) && composition.Transparent[i]
+		visible := composition.DocumentIDs[i] != "" && !transparent
+		if visible != (tile >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// stickerRef позиция стикера в наборе семьи
+type stickerRef struct {
+	link  string
+	index int
+}
+
+// replaceComposition заменяет каждый стикер композиции через ReplaceStickerInSet.
+// Замена сохраняет позицию стикера, но выдает ему новый id эмодзи, поэтому
+// позиция запоминается в чекпоинте до замены, композиция сохраняется после нее,
+// а замененные ячейки отмечаются: повторная попытка продолжит с первой незамененной.
+func (d *DripBot) replaceComposition(ctx context.Context, args *types.EmojiCommand, emojiFiles []string, empty []bool, composition *db.Composition, layout [][]int) (*models.StickerSet, [][]types.EmojiMeta, error) {
+	ticket, err := d.waitPack(ctx, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ticket.Release()
+
+	uploaded := make(map[int]types.UploadedTile)
+	if args.Checkpoint != nil {
+		uploaded, err = args.Checkpoint.Load(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	emojiFileIDs, emojiMetaRows, err := d.uploadEmojiFiles(ctx, args, emojiFiles, empty, uploaded)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sets, refs, err := d.familyStickers(ctx, args.PackLink)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slot := 0
+	for i, tile := range slices.Concat(layout...) {
+		if tile < 0 {
+			continue
+		}
+		k := slot
+		slot++
+		if uploaded[k].Added {
+			continue
+		}
+
+		ref, err := d.replaceTarget(ctx, args, uploaded[k], k, refs, composition.DocumentIDs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := sets[ref.link]; !ok || ref.index >= len(sets[ref.link].Stickers) {
+			err := fmt.Errorf("sticker %d of %s for composition %d not found", ref.index, ref.link, composition.ID)
+			return nil, nil, &jobError{Message: "Стикеры композиции не найдены в паке", Err: err}
+		}
+
+		err = retry.Exec(ctx, retry.AddSticker, func(ctx context.Context) error {
+			_, err := d.bot.ReplaceStickerInSet(ctx, &bot.ReplaceStickerInSetParams{
+				UserID:     args.UserID,
+				Name:       ref.link,
+				OldSticker: sets[ref.link].Stickers[ref.index].FileID,
+				Sticker: models.InputSticker{
+					Sticker: &models.InputFileString{Data: emojiFileIDs[k]},
+					Format:  defaultStickerFormat,
+					EmojiList: []string{
+						defaultEmojiIcon,
+					},
+				},
+			})
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("replace sticker in set: %w", err)
+		}
+
+		set, err := d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{Name: ref.link})
+		if err != nil {
+			return nil, nil, fmt.Errorf("get sticker set: %w", err)
+		}
+		sets[ref.link] = set
+		composition.DocumentIDs[i] = set.Stickers[ref.index].CustomEmojiID
+		if err := db.Postgres.UpdateComposition(ctx, composition); err != nil {
+			return nil, nil, err
+		}
+		d.saveAdded(ctx, args, ref.link, k)
+	}
+
+	composition.Command = args.RawInitCommand
+	if err := db.Postgres.UpdateComposition(ctx, composition); err != nil {
+		slog.Error("Failed to update composition", slog.String("err", err.Error()), slog.Int64("composition_id", composition.ID))
+	}
+
+	// Тайлы остались в тех же ячейках, id эмодзи берем из композиции
+	i := 0
+	for row := range emojiMetaRows {
+		for pos := range emojiMetaRows[row] {
+			if meta := emojiMetaRows[row][pos]; meta.FileID != "" && !meta.Transparent {
+				emojiMetaRows[row][pos].DocumentID = composition.DocumentIDs[i]
+			}
+			i++
+		}
+	}
+
+	set, ok := sets[args.PackLink]
+	if !ok {
+		return nil, nil, fmt.Errorf("sticker set %s not found", args.PackLink)
+	}
+	return set, emojiMetaRows, nil
+}
+
+// replaceTarget возвращает стикер, который заменяет ячейка slot. Если прошлая
+// попытка успела его запомнить, берется сохраненная позиция: замена могла пройти,
+// а id эмодзи в композиции остаться старым. Иначе стикер ищется по documentID,
+// и его позиция сохраняется в чекпоинте до замены.
+func (d *DripBot) replaceTarget(ctx context.Context, args *types.EmojiCommand, tile types.UploadedTile, slot int, refs map[string]stickerRef, documentID string) (stickerRef, error) {
+	if tile.PackLink != "" && tile.StickerIndex >= 0 {
+		return stickerRef{link: tile.PackLink, index: tile.StickerIndex}, nil
+	}
+
+	ref, ok := refs[documentID]
+	if !ok {
+		err := fmt.Errorf("sticker %s of composition %d not found", documentID, args.Composition)
+		return stickerRef{}, &jobError{Message: "Стикеры композиции не найдены в паке", Err: err}
+	}
+
+	if args.Checkpoint != nil {
+		if err := args.Checkpoint.SaveTarget(ctx, slot, ref.link, ref.index); err != nil {
+			return stickerRef{}, err
+		}
+	}
+	return ref, nil
+}
+
+// familyStickers возвращает наборы семьи packLink и позиции их стикеров по id эмодзи
+func (d *DripBot) familyStickers(ctx context.Context, packLink string) (map[string]*models.StickerSet, map[string]stickerRef, error) {
+	family, err := db.Postgres.GetPackFamily(ctx, packLink)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sets := make(map[string]*models.StickerSet, len(family))
+	refs := make(map[string]stickerRef)
+	for _, pack := range family {
+		set, err := d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{Name: *pack.PackLink})
+		if err != nil {
+			if isStickerSetInvalid(err) {
+				continue
+			}
+			return nil, nil, fmt.Errorf("get sticker set: %w", err)
+		}
+
+		sets[*pack.PackLink] = set
+		for index, sticker := range set.Stickers {
+			refs[sticker.CustomEmojiID] = stickerRef{link: *pack.PackLink, index: index}
+		}
+	}
+	return sets, refs, nil
+}
@@ -0,0 +1,135 @@
+package bots
+
+import (
+	"context"
+	"emoji-generator/processing"
+	"emoji-generator/retry"
+	"emoji-generator/types"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// spacerSetTitle название служебного набора с прозрачным разделителем
+const spacerSetTitle = "⠀"
+
+// spacerPackName имя служебного набора бота, в котором хранится прозрачный разделитель
+func (d *DripBot) spacerPackName() string {
+	return "spacer_by_" + d.BotUserName()
+}
+
+// spacer возвращает прозрачный разделитель для отступов узких композиций.
+// Разделитель загружается один раз в служебный набор бота и дальше берется из кэша,
+// поэтому отступы не занимают места в паке пользователя. Если набора еще нет,
+// он создается от имени администратора SPACER_OWNER_ID: удалить общий набор может
+// только его владелец. Без SPACER_OWNER_ID владельцем становится userID, как раньше.
+func (d *DripBot) spacer(ctx context.Context, userID int64) (types.EmojiMeta, error) {
+	d.spacerMu.Lock()
+	defer d.spacerMu.Unlock()
+
+	if d.spacerEmoji.DocumentID != "" {
+		return d.spacerEmoji, nil
+	}
+
+	set, err := d.getSpacerSet(ctx)
+	if err != nil && !isStickerSetInvalid(err) {
+		return types.EmojiMeta{}, err
+	}
+	if err != nil {
+		set, err = d.createSpacerSet(ctx, userID)
+		if err != nil {
+			return types.EmojiMeta{}, err
+		}
+	}
+	if len(set.Stickers) == 0 {
+		return types.EmojiMeta{}, fmt.Errorf("spacer set %s is empty", set.Name)
+	}
+
+	d.spacerEmoji = types.EmojiMeta{
+		FileID:      set.Stickers[0].FileID,
+		DocumentID:  set.Stickers[0].CustomEmojiID,
+		FileName:    set.Name,
+		Transparent: true,
+	}
+	return d.spacerEmoji, nil
+}
+
+func (d *DripBot) getSpacerSet(ctx context.Context) (*models.StickerSet, error) {
+	set, err := retry.Do(ctx, retry.Read, func(ctx context.Context) (*models.StickerSet, error) {
+		return d.bot.GetStickerSet(ctx, &bot.GetStickerSetParams{
+			Name: d.spacerPackName(),
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get spacer set: %w", err)
+	}
+	return set, nil
+}
+
+// forgetSpacer сбрасывает кэш разделителя, если его набора больше нет или в нем
+// другой стикер. Вызывается после неудачной отправки композиции с отступами:
+// следующая генерация создаст набор заново.
+func (d *DripBot) forgetSpacer(ctx context.Context, emojis []types.EmojiMeta) {
+	if !slices.ContainsFunc(emojis, func(e types.EmojiMeta) bool { return e.Transparent }) {
+		return
+	}
+
+	d.spacerMu.Lock()
+	defer d.spacerMu.Unlock()
+	if d.spacerEmoji.DocumentID == "" {
+		return
+	}
+
+	set, err := d.getSpacerSet(ctx)
+	if err != nil && !isStickerSetInvalid(err) {
+		slog.Error("Failed to check spacer set", slog.String("err", err.Error()))
+		return
+	}
+	if err == nil && len(set.Stickers) > 0 && set.Stickers[0].CustomEmojiID == d.spacerEmoji.DocumentID {
+		return
+	}
+
+	slog.Warn("spacer set is gone, dropping cached spacer", slog.String("name", d.spacerPackName()))
+	d.spacerEmoji = types.EmojiMeta{}
+}
+
+func (d *DripBot) createSpacerSet(ctx context.Context, userID int64) (*models.StickerSet, error) {
+	if d.spacerOwner != 0 {
+		userID = d.spacerOwner
+	}
+
+	data, err := processing.SpacerTile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fileID, err := d.uploadSticker(ctx, userID, "spacer.webm", data)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.bot.CreateNewStickerSet(ctx, &bot.CreateNewStickerSetParams{
+		UserID:      userID,
+		Name:        d.spacerPackName(),
+		Title:       spacerSetTitle,
+		StickerType: "custom_emoji",
+		Stickers: []models.InputSticker{{
+			Sticker:   &models.InputFileString{Data: fileID},
+			Format:    defaultStickerFormat,
+			EmojiList: []string{defaultEmojiIcon},
+		}},
+	})
+	if err != nil {
+		// Набор мог успеть создать другой процесс
+		if set, getErr := d.getSpacerSet(ctx); getErr == nil {
+			return set, nil
+		}
+		return nil, fmt.Errorf("create spacer set: %w", err)
+	}
+
+	slog.Info("spacer set created", slog.String("name", d.spacerPackName()), slog.Int64("owner", userID))
+	return d.getSpacerSet(ctx)
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"emoji-generator/types"
+	"fmt"
+)
+
+// NewComposition собирает запись композиции из сетки emojiMetaRows
+func NewComposition(packID int64, args *types.EmojiCommand, rows [][]types.EmojiMeta) *Composition {
+	c := &Composition{
+		PackID:   packID,
+		UserID:   args.UserID,
+		Width:    args.Width,
+		Command:  args.RawInitCommand,
+		MimeType: args.MimeType,
+	}
+	if args.File != nil {
+		c.FileID = args.File.FileID
+	}
+
+	for _, row := range rows {
+		for _, emoji := range row {
+			c.DocumentIDs = append(c.DocumentIDs, emoji.DocumentID)
+			c.Transparent = append(c.Transparent, emoji.Transparent)
+		}
+	}
+	return c
+}
+
+// Rows восстанавливает сетку композиции. Ширина ряда та же, что при генерации:
+// узкие композиции дополнены отступами до types.DefaultWidth.
+func (c *Composition) Rows() [][]types.EmojiMeta {
+	rowWidth := max(c.Width, types.DefaultWidth)
+
+	var rows [][]types.EmojiMeta
+	for i, documentID := range c.DocumentIDs {
+		if i%rowWidth == 0 {
+			rows = append(rows, make([]types.EmojiMeta, 0, rowWidth))
+		}
+		last := len(rows) - 1
+		rows[last] = append(rows[last], types.EmojiMeta{
+			DocumentID:  documentID,
+			Transparent: i < len(c.Transparent) && c.Transparent[i],
+		})
+	}
+	return rows
+}
+
+// CreateComposition сохраняет композицию
+func (p *postgres) CreateComposition(ctx context.Context, c *Composition) (*Composition, error) {
+	query := `
+INSERT INTO compositions (pack_id, user_id, width, document_ids, transparent, file_id, mime_type, command)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, created_at, updated_at`
+
+	err := p.db.QueryRowContext(ctx, query, c.PackID, c.UserID, c.Width, c.DocumentIDs, c.Transparent, c.FileID, c.MimeType, c.Command).
+		Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composition: %w", err)
+	}
+
+	return c, nil
+}
+
+// GetUserCompositions возвращает последние композиции пользователя в неудаленных паках бота
+func (p *postgres) GetUserCompositions(ctx context.Context, userID int64, botName string, limit int) ([]*Composition, error) {
+	var compositions []*Composition
+	query := `
+SELECT c.*, COALESCE(p.pack_link, '') AS pack_link FROM compositions c
+JOIN emoji_packs p ON p.id = c.pack_id
+WHERE c.user_id = $1 AND p.bot_name = $2 AND c.deleted = false AND p.deleted = false
+ORDER BY c.created_at DESC
+LIMIT $3`
+
+	if err := p.db.SelectContext(ctx, &compositions, query, userID, botName, limit); err != nil {
+		return nil, fmt.Errorf("failed to get user compositions: %w", err)
+	}
+
+	return compositions, nil
+}
+
+// GetComposition возвращает неудаленную композицию пользователя
+func (p *postgres) GetComposition(ctx context.Context, id int64, userID int64) (*Composition, error) {
+	var c Composition
+	query := `
+SELECT c.*, COALESCE(p.pack_link, '') AS pack_link FROM compositions c
+JOIN emoji_packs p ON p.id = c.pack_id
+WHERE c.id = $1 AND c.user_id = $2 AND c.deleted = false AND p.deleted = false`
+
+	if err := p.db.GetContext(ctx, &c, query, id, userID); err != nil {
+		return nil, fmt.Errorf("failed to get composition: %w", err)
+	}
+
+	return &c, nil
+}
+
+// GetCompositionByDocumentID возвращает неудаленную композицию пользователя, в которой
+// кастомный эмодзи documentID - тайл, а не разделитель
+func (p *postgres) GetCompositionByDocumentID(ctx context.Context, documentID string, userID int64) (*Composition, error) {
+	var c Composition
+	query := `
+SELECT c.*, COALESCE(p.pack_link, '') AS pack_link FROM compositions c
+JOIN emoji_packs p ON p.id = c.pack_id
+WHERE c.user_id = $2 AND $1 = ANY(c.document_ids) AND c.deleted = false AND p.deleted = false
+	AND c.transparent[array_position(c.document_ids, $1)] = false
+ORDER BY c.created_at DESC
+LIMIT 1`
+
+	if err := p.db.GetContext(ctx, &c, query, documentID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get composition by document id: %w", err)
+	}
+
+	return &c, nil
+}
+
+// UpdateComposition сохраняет перегенерированную на месте композицию
+func (p *postgres) UpdateComposition(ctx context.Context, c *Composition) error {
+	query := `
+UPDATE compositions SET document_ids = $1, transparent = $2, file_id = $3, mime_type = $4, command = $5, updated_at = NOW()
+WHERE id = $6`
+
+	if _, err := p.db.ExecContext(ctx, query, c.DocumentIDs, c.Transparent, c.FileID, c.MimeType, c.Command, c.ID); err != nil {
+		return fmt.Errorf("failed to update composition: %w", err)
+	}
+	return nil
+}
+
+// GetPackCompositions возвращает неудаленные композиции пользователя в семье пака
+// packLink: корневом паке и его паках-продолжениях
+func (p *postgres) GetPackCompositions(ctx context.Context, packLink string, userID int64) ([]*Composition, error) {
+	var compositions []*Composition
+	query := `
+WITH root AS (
+	SELECT COALESCE(parent_id, id) AS id FROM emoji_packs WHERE pack_link = $1 AND deleted = false LIMIT 1
+)
+SELECT c.*, COALESCE(p.pack_link, '') AS pack_link FROM compositions c
+JOIN emoji_packs p ON p.id = c.pack_id
+WHERE (p.id = (SELECT id FROM root) OR p.parent_id = (SELECT id FROM root))
+	AND c.user_id = $2 AND c.deleted = false AND p.deleted = false
+ORDER BY c.created_at`
+
+	if err := p.db.SelectContext(ctx, &compositions, query, packLink, userID); err != nil {
+		return nil, fmt.Errorf("failed to get pack compositions: %w", err)
+	}
+
+	return compositions, nil
+}
+
+// SetDeletedComposition помечает композицию удаленной
+func (p *postgres) SetDeletedComposition(ctx context.Context, id int64) error {
+	query := `UPDATE compositions SET deleted = true, updated_at = NOW() WHERE id = $1`
+	if _, err := p.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to delete composition: %w", err)
+	}
+	return nil
+}
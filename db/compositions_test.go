@@ -0,0 +1,26 @@
+package db
+
+import (
+	"emoji-generator/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewComposition_UserbotSource(t *testing.T) {
+	args := &types.EmojiCommand{UserID: 42, Width: 8, RawInitCommand: "/emoji"}
+	args.SetUserbotSource(42, 1337, "video/mp4")
+
+	c := NewComposition(1, args, nil)
+	require.Equal(t, "video/mp4", c.MimeType)
+	require.NotEmpty(t, c.FileID)
+
+	chatID, msgID, ok := types.ParseUserbotSource(c.FileID)
+	require.True(t, ok)
+	require.Equal(t, int64(42), chatID)
+	require.Equal(t, 1337, msgID)
+}
+
+func TestParseUserbotSource_BotFileID(t *testing.T) {
+	_, _, ok := types.ParseUserbotSource("AgACAgIAAxkBAAIB")
+	require.False(t, ok)
+}
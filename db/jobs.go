@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"emoji-generator/types"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EnqueueJob ставит задачу генерации в очередь
+func (p *postgres) EnqueueJob(ctx context.Context, job *Job) (*Job, error) {
+	query := `
+INSERT INTO jobs (
+bot_name, kind, status, command, file_id, mime_type, user_id, chat_id, thread_id, reply_to, progress_message_id
+) VALUES (
+$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+) RETURNING id, created_at, updated_at`
+
+	job.Status = JobStatusQueued
+	err := p.db.QueryRowContext(ctx, query, job.BotName, job.Kind, job.Status, job.Command, job.FileID, job.MimeType,
+		job.UserID, job.ChatID, job.ThreadID, job.ReplyTo, job.ProgressMessageID).
+		Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return job, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	return job, nil
+}
+
+// ClaimJob забирает самую старую задачу бота. Задачи, чей воркер перестал
+// продлевать аренду (например, после рестарта), забираются повторно, пока не
+// исчерпан maxAttempts. Возвращает nil, если задач нет.
+func (p *postgres) ClaimJob(ctx context.Context, botName, workerID string, lease time.Duration, maxAttempts int) (*Job, error) {
+	expire := `
+UPDATE jobs SET status = $1, error = 'превышено количество попыток', locked_by = NULL, updated_at = NOW()
+WHERE bot_name = $2 AND status = $3 AND locked_at < NOW() - $4::interval AND attempts >= $5`
+
+	if _, err := p.db.ExecContext(ctx, expire, JobStatusFailed, botName, JobStatusRunning, lease.String(), maxAttempts); err != nil {
+		return nil, fmt.Errorf("failed to expire stale jobs: %w", err)
+	}
+
+	query := `
+UPDATE jobs SET status = $1, locked_by = $2, locked_at = NOW(), attempts = attempts + 1, updated_at = NOW()
+WHERE id = (
+	SELECT id FROM jobs
+	WHERE bot_name = $3 AND (status = $4 OR (status = $1 AND locked_at < NOW() - $5::interval))
+	ORDER BY created_at
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+) RETURNING *`
+
+	var job Job
+	err := p.db.GetContext(ctx, &job, query, JobStatusRunning, workerID, botName, JobStatusQueued, lease.String())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// HeartbeatJob продлевает аренду задачи воркером. Возвращает false, если задача
+// больше не принадлежит воркеру, например была отменена.
+func (p *postgres) HeartbeatJob(ctx context.Context, jobID int64, workerID string) (bool, error) {
+	query := `UPDATE jobs SET locked_at = NOW() WHERE id = $1 AND locked_by = $2 AND status = $3`
+	res, err := p.db.ExecContext(ctx, query, jobID, workerID, JobStatusRunning)
+	if err != nil {
+		return false, fmt.Errorf("failed to heartbeat job: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to heartbeat job: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// CancelUserJobs отменяет ожидающие и выполняющиеся задачи пользователя.
+// Возвращает отмененные задачи со статусом, который был до отмены.
+func (p *postgres) CancelUserJobs(ctx context.Context, userID int64) ([]Job, error) {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var jobs []Job
+	query := `SELECT * FROM jobs WHERE user_id = $1 AND status IN ($2, $3) ORDER BY created_at FOR UPDATE`
+	if err := tx.SelectContext(ctx, &jobs, query, userID, JobStatusQueued, JobStatusRunning); err != nil {
+		return nil, fmt.Errorf("failed to get user jobs: %w", err)
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE job_stages SET status = $1, finished_at = NOW() WHERE job_id = ANY($2) AND finished_at IS NULL`, JobStatusCanceled, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("failed to cancel job stages: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE jobs SET status = $1, updated_at = NOW() WHERE id = ANY($2)`, JobStatusCanceled, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("failed to cancel jobs: %w", err)
+	}
+
+	return jobs, tx.Commit()
+}
+
+// SetJobStage закрывает текущий этап задачи и открывает следующий
+func (p *postgres) SetJobStage(ctx context.Context, jobID int64, stage string) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE job_stages SET status = $1, finished_at = NOW() WHERE job_id = $2 AND finished_at IS NULL`, JobStatusDone, jobID); err != nil {
+		return fmt.Errorf("failed to finish job stage: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO job_stages (job_id, stage, status) VALUES ($1, $2, $3)`, jobID, stage, JobStatusRunning); err != nil {
+		return fmt.Errorf("failed to start job stage: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE jobs SET stage = $1, updated_at = NOW() WHERE id = $2`, stage, jobID); err != nil {
+		return fmt.Errorf("failed to set job stage: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// FinishJob фиксирует итоговый статус задачи и ее последнего этапа. Отмененная
+// задача остается отмененной, даже если воркер успел ее завершить: пользователю
+// уже ответили, что генерация отменена.
+func (p *postgres) FinishJob(ctx context.Context, jobID int64, status string, jobErr error) error {
+	var errText *string
+	if jobErr != nil {
+		text := jobErr.Error()
+		errText = &text
+	}
+
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE jobs SET status = $1, error = $2, locked_by = NULL, updated_at = NOW()
+WHERE id = $3 AND (status <> $4 OR $1 = $4)`, status, errText, jobID, JobStatusCanceled)
+	if err != nil {
+		return fmt.Errorf("failed to finish job: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to finish job: %w", err)
+	} else if n == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE job_stages SET status = $1, error = $2, finished_at = NOW() WHERE job_id = $3 AND finished_at IS NULL`, status, errText, jobID); err != nil {
+		return fmt.Errorf("failed to finish job stage: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// RequeueJob возвращает прерванную задачу в очередь, например при остановке бота
+func (p *postgres) RequeueJob(ctx context.Context, jobID int64) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE job_stages SET status = $1, finished_at = NOW() WHERE job_id = $2 AND finished_at IS NULL`, JobStatusQueued, jobID); err != nil {
+		return fmt.Errorf("failed to interrupt job stage: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE jobs SET status = $1, locked_by = NULL, locked_at = NULL, updated_at = NOW() WHERE id = $2`, JobStatusQueued, jobID); err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// JobCheckpoint прогресс загрузки эмодзи задачи в таблице job_tiles
+type JobCheckpoint struct {
+	JobID int64
+}
+
+// Load возвращает загруженные ячейки композиции по номеру ячейки
+func (c JobCheckpoint) Load(ctx context.Context) (map[int]types.UploadedTile, error) {
+	var tiles []types.UploadedTile
+	query := `SELECT slot, file_path, file_id, added, pack_link, sticker_index FROM job_tiles WHERE job_id = $1`
+	if err := Postgres.db.SelectContext(ctx, &tiles, query, c.JobID); err != nil {
+		return nil, fmt.Errorf("failed to load job tiles: %w", err)
+	}
+
+	uploaded := make(map[int]types.UploadedTile, len(tiles))
+	for _, tile := range tiles {
+		uploaded[tile.Slot] = tile
+	}
+	return uploaded, nil
+}
+
+// SaveUploaded запоминает file ID загруженной ячейки
+func (c JobCheckpoint) SaveUploaded(ctx context.Context, slot int, filePath string, fileID string) error {
+	query := `
+INSERT INTO job_tiles (job_id, slot, file_path, file_id) VALUES ($1, $2, $3, $4)
+ON CONFLICT (job_id, slot) DO UPDATE SET file_path = $3, file_id = $4, added = false, updated_at = NOW()`
+
+	if _, err := Postgres.db.ExecContext(ctx, query, c.JobID, slot, filePath, fileID); err != nil {
+		return fmt.Errorf("failed to save uploaded tile: %w", err)
+	}
+	return nil
+}
+
+// SaveAdded отмечает ячейки, добавленные в набор packLink
+func (c JobCheckpoint) SaveAdded(ctx context.Context, packLink string, slots ...int) error {
+	ids := make([]int64, len(slots))
+	for i, slot := range slots {
+		ids[i] = int64(slot)
+	}
+
+	query := `UPDATE job_tiles SET added = true, pack_link = $3, updated_at = NOW() WHERE job_id = $1 AND slot = ANY($2)`
+	if _, err := Postgres.db.ExecContext(ctx, query, c.JobID, pq.Array(ids), packLink); err != nil {
+		return fmt.Errorf("failed to save added tiles: %w", err)
+	}
+	return nil
+}
+
+// SaveTarget запоминает, какой стикер заменяет ячейка. Позиция сохраняется до
+// замены: после нее у стикера новый id эмодзи, и найти его по старому нельзя.
+func (c JobCheckpoint) SaveTarget(ctx context.Context, slot int, packLink string, index int) error {
+	query := `UPDATE job_tiles SET pack_link = $3, sticker_index = $4, updated_at = NOW() WHERE job_id = $1 AND slot = $2`
+	if _, err := Postgres.db.ExecContext(ctx, query, c.JobID, slot, packLink, index); err != nil {
+		return fmt.Errorf("failed to save tile target: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 )
 
@@ -9,12 +10,12 @@ import (
 func (p *postgres) CreateEmojiPack(ctx context.Context, pack *EmojiPack) (*EmojiPack, error) {
 	query := `
 INSERT INTO emoji_packs (
-creator_id, pack_name, telegram_file_id, pack_link, initial_command, bot_name, emoji_count
+creator_id, pack_name, telegram_file_id, pack_link, initial_command, bot_name, emoji_count, parent_id
 ) VALUES (
-$1, $2, $3, $4, $5, $6, $7
+$1, $2, $3, $4, $5, $6, $7, $8
 ) RETURNING id, created_at, updated_at`
 
-	err := p.db.QueryRowContext(ctx, query, pack.CreatorID, pack.PackName, pack.TelegramFileID, pack.PackLink, pack.InitialCommand, pack.BotName, pack.EmojiCount).
+	err := p.db.QueryRowContext(ctx, query, pack.CreatorID, pack.PackName, pack.TelegramFileID, pack.PackLink, pack.InitialCommand, pack.BotName, pack.EmojiCount, pack.ParentID).
 		Scan(&pack.ID, &pack.CreatedAt, &pack.UpdatedAt)
 	if err != nil {
 		return pack, fmt.Errorf("failed to create emoji pack: %w", err)
@@ -35,14 +36,15 @@ func (p *postgres) GetEmojiPackByID(ctx context.Context, id int64) (*EmojiPack,
 	return &pack, nil
 }
 
-// GetEmojiPacksByCreator retrieves all emoji packs created by a specific user
+// GetEmojiPacksByCreator retrieves all emoji packs created by a specific user.
+// A pack family is listed once, by its root pack.
 func (p *postgres) GetEmojiPacksByCreator(ctx context.Context, creatorID int64, botName string, includeBlank bool) ([]*EmojiPack, error) {
 	var packs []*EmojiPack
 	var query string
 	if includeBlank {
-		query = `SELECT * FROM emoji_packs WHERE creator_id = $1 and bot_name = $2 and deleted = false ORDER BY created_at DESC`
+		query = `SELECT * FROM emoji_packs WHERE creator_id = $1 and bot_name = $2 and deleted = false AND parent_id IS NULL ORDER BY created_at DESC`
 	} else {
-		query = `SELECT * FROM emoji_packs WHERE creator_id = $1 AND pack_link is not null AND bot_name = $2 and deleted = false ORDER BY created_at DESC`
+		query = `SELECT * FROM emoji_packs WHERE creator_id = $1 AND pack_link is not null AND bot_name = $2 and deleted = false AND parent_id IS NULL ORDER BY created_at DESC`
 	}
 
 	if err := p.db.SelectContext(ctx, &packs, query, creatorID, botName); err != nil {
@@ -64,6 +66,28 @@ func (p *postgres) GetEmojiPackByPackLink(ctx context.Context, packLink string)
 	return &pack, nil
 }
 
+// GetPackFamily returns the family of the pack with the given link: the root
+// pack first, then its continuation packs in creation order
+func (p *postgres) GetPackFamily(ctx context.Context, packLink string) ([]*EmojiPack, error) {
+	var packs []*EmojiPack
+	query := `
+WITH root AS (
+	SELECT COALESCE(parent_id, id) AS id FROM emoji_packs WHERE pack_link = $1 AND deleted = false LIMIT 1
+)
+SELECT * FROM emoji_packs
+WHERE (id = (SELECT id FROM root) OR parent_id = (SELECT id FROM root)) AND deleted = false
+ORDER BY parent_id IS NOT NULL, id`
+
+	if err := p.db.SelectContext(ctx, &packs, query, packLink); err != nil {
+		return nil, fmt.Errorf("failed to get pack family: %w", err)
+	}
+	if len(packs) == 0 {
+		return nil, fmt.Errorf("failed to get pack family: %w", sql.ErrNoRows)
+	}
+
+	return packs, nil
+}
+
 func (p *postgres) SetDeletedPack(ctx context.Context, packLink string) error {
 	query := `UPDATE emoji_packs SET deleted = true WHERE pack_link = $1`
 	_, err := p.db.ExecContext(ctx, query, packLink)
@@ -2,6 +2,8 @@ package db
 
 import (
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type EmojiPack struct {
@@ -15,6 +17,7 @@ type EmojiPack struct {
 	EmojiCount     int       `db:"emoji_count"`
 	Completed      bool      `db:"completed"`
 	Deleted        bool      `json:"deleted"`
+	ParentID       *int64    `db:"parent_id"` // корневой пак семьи, nil у самого корня
 	CreatedAt      time.Time `db:"created_at"`
 	UpdatedAt      time.Time `db:"updated_at"`
 }
@@ -25,3 +28,61 @@ type Bot struct {
 	Blocked   bool      `db:"blocked"`
 	CreatedAt time.Time `db:"created_at"`
 }
+
+const (
+	JobStatusQueued   = "queued"
+	JobStatusRunning  = "running"
+	JobStatusDone     = "done"
+	JobStatusFailed   = "failed"
+	JobStatusCanceled = "canceled"
+)
+
+const (
+	JobKindChat = "chat"
+	JobKindDM   = "dm"
+	// JobKindUserbot команда в личных сообщениях userbot: файл уже скачан
+	// userbot в рабочую директорию, ответы отправляет он же
+	JobKindUserbot = "userbot"
+)
+
+// Job задача генерации пака, переживающая рестарт
+type Job struct {
+	ID                int64      `db:"id"`
+	BotName           string     `db:"bot_name"`
+	Kind              string     `db:"kind"`
+	Status            string     `db:"status"`
+	Stage             string     `db:"stage"`
+	Command           []byte     `db:"command"` // types.EmojiCommand в JSON
+	FileID            string     `db:"file_id"`
+	MimeType          string     `db:"mime_type"`
+	UserID            int64      `db:"user_id"`
+	ChatID            int64      `db:"chat_id"`
+	ThreadID          int        `db:"thread_id"`
+	ReplyTo           int        `db:"reply_to"`
+	ProgressMessageID int        `db:"progress_message_id"`
+	Attempts          int        `db:"attempts"`
+	Error             *string    `db:"error"`
+	LockedBy          *string    `db:"locked_by"`
+	LockedAt          *time.Time `db:"locked_at"`
+	CreatedAt         time.Time  `db:"created_at"`
+	UpdatedAt         time.Time  `db:"updated_at"`
+}
+
+// Composition композиция, собранная одной командой /emoji: какие кастомные
+// эмодзи и в каком порядке образуют картинку
+type Composition struct {
+	ID          int64          `db:"id"`
+	PackID      int64          `db:"pack_id"`
+	UserID      int64          `db:"user_id"`
+	Width       int            `db:"width"`
+	DocumentIDs pq.StringArray `db:"document_ids"` // сетка по строкам, "" - пустая ячейка
+	Transparent pq.BoolArray   `db:"transparent"`
+	FileID      string         `db:"file_id"` // исходный файл
+	MimeType    string         `db:"mime_type"`
+	Command     string         `db:"command"`
+	Deleted     bool           `db:"deleted"`
+	CreatedAt   time.Time      `db:"created_at"`
+	UpdatedAt   time.Time      `db:"updated_at"`
+
+	PackLink string `db:"pack_link"` // из emoji_packs, заполняется при чтении
+}
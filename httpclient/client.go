@@ -1,7 +1,6 @@
 package httpclient
 
 import (
-	"context"
 	"golang.org/x/time/rate"
 	"net/http"
 )
@@ -14,9 +13,9 @@ type RLHTTPClient struct {
 
 // Do dispatches the HTTP request to the network
 func (c *RLHTTPClient) Do(req *http.Request) (*http.Response, error) {
-	// Comment out the below 5 lines to turn off ratelimiting
-	ctx := context.Background()
-	err := c.Ratelimiter.Wait(ctx) // This is a blocking call. Honors the rate limit
+	// Comment out the below 4 lines to turn off ratelimiting.
+	// Waits on the request context, so a canceled upload does not hold a slot
+	err := c.Ratelimiter.Wait(req.Context()) // This is a blocking call. Honors the rate limit
 	if err != nil {
 		return nil, err
 	}
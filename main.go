@@ -5,12 +5,14 @@ import (
 	"emoji-generator/bots"
 	"emoji-generator/db"
 	userbot "emoji-generator/mtproto"
+	"emoji-generator/scheduler"
 	"github.com/joho/godotenv"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -25,6 +27,11 @@ func main() {
 
 	slog.SetLogLoggerLevel(slog.LevelDebug)
 
+	if err := scheduler.Init(); err != nil {
+		log.Fatalf("Error initializing ffmpeg scheduler: %v", err)
+	}
+	go scheduler.FFmpeg.ReportStats(ctx, time.Minute)
+
 	err := db.Init()
 	if err != nil {
 		log.Fatalf("Error initializing DB: %v", err)
@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    bot_name VARCHAR(255) NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'queued',
+    stage VARCHAR(64) NOT NULL DEFAULT '',
+    command JSONB NOT NULL,
+    file_id TEXT NOT NULL,
+    mime_type VARCHAR(255) NOT NULL,
+    user_id BIGINT NOT NULL,
+    chat_id BIGINT NOT NULL,
+    thread_id INT NOT NULL DEFAULT 0,
+    reply_to INT NOT NULL DEFAULT 0,
+    progress_message_id INT NOT NULL DEFAULT 0,
+    attempts INT NOT NULL DEFAULT 0,
+    error TEXT,
+    locked_by TEXT,
+    locked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE job_stages (
+    id BIGSERIAL PRIMARY KEY,
+    job_id BIGINT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    stage VARCHAR(64) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'running',
+    error TEXT,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create indexes
+CREATE INDEX idx_jobs_claim ON jobs(bot_name, status, created_at);
+CREATE INDEX idx_jobs_user ON jobs(user_id, status);
+CREATE INDEX idx_job_stages_job ON job_stages(job_id);
+
+GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO drip_tech;
+GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public TO drip_tech;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS job_stages;
+DROP TABLE IF EXISTS jobs;
+-- +goose StatementEnd
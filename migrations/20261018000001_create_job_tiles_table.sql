@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE job_tiles (
+    job_id BIGINT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    slot INT NOT NULL,
+    file_path TEXT NOT NULL,
+    file_id TEXT NOT NULL,
+    added BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (job_id, slot)
+);
+
+GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO drip_tech;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS job_tiles;
+-- +goose StatementEnd
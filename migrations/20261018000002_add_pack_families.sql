@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Паки-продолжения ссылаются на корневой пак семьи
+ALTER TABLE emoji_packs ADD COLUMN parent_id INT REFERENCES emoji_packs(id);
+CREATE INDEX idx_emoji_packs_parent ON emoji_packs(parent_id);
+
+-- В какой пак семьи добавлена ячейка
+ALTER TABLE job_tiles ADD COLUMN pack_link TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE job_tiles DROP COLUMN IF EXISTS pack_link;
+DROP INDEX IF EXISTS idx_emoji_packs_parent;
+ALTER TABLE emoji_packs DROP COLUMN IF EXISTS parent_id;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE compositions (
+    id SERIAL PRIMARY KEY,
+    pack_id INT NOT NULL REFERENCES emoji_packs(id),
+    user_id BIGINT NOT NULL,
+    width INT NOT NULL,
+    -- Сетка композиции по строкам: id кастомных эмодзи, пустая строка - пустая ячейка
+    document_ids TEXT[] NOT NULL,
+    transparent BOOLEAN[] NOT NULL,
+    file_id TEXT NOT NULL DEFAULT '',
+    command TEXT NOT NULL DEFAULT '',
+    deleted BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_compositions_pack ON compositions(pack_id);
+CREATE INDEX idx_compositions_user ON compositions(user_id);
+
+GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO drip_tech;
+GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public TO drip_tech;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS compositions;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Тип исходного файла нужен, чтобы /redo скачал его заново
+ALTER TABLE compositions ADD COLUMN mime_type TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE compositions DROP COLUMN IF EXISTS mime_type;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Позиция стикера в наборе pack_link, который заменяет ячейка при /redo
+ALTER TABLE job_tiles ADD COLUMN sticker_index INT NOT NULL DEFAULT -1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE job_tiles DROP COLUMN IF EXISTS sticker_index;
+-- +goose StatementEnd
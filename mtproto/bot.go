@@ -93,6 +93,13 @@ func (u *User) Init(ctx context.Context) error {
 
 	dispatcher.AddHandlerToGroup(handlers.NewMessage(emojiCmd, u.emoji), 0)
 
+	cancelCmd, err := filters.Message.Regex("^/cancel")
+	if err != nil {
+		return fmt.Errorf("ошибка создания regex: %v", err)
+	}
+
+	dispatcher.AddHandlerToGroup(handlers.NewMessage(cancelCmd, u.cancel), 0)
+
 	//dispatcher.AddHandlerToGroup(handlers.NewMessage(filters.Message.Text, u.echo), 0)
 
 	return nil
@@ -196,15 +203,11 @@ func (u *User) styledText(width int, packLink string, emojis []types.EmojiMeta)
 		if i == len(emojis)-1 || i == types.MaxStickerInMessage-1 {
 			break
 		}
-		if emoji.Transparent {
-			formats = append(formats, styling.Plain("⠀⠀"))
-		} else {
-			documentID, err := strconv.ParseInt(emoji.DocumentID, 10, 64)
-			if err != nil {
-				return nil, fmt.Errorf("ошибка при парсинге id документа: %v", err)
-			}
-			formats = append(formats, styling.CustomEmoji("⭐️", documentID))
+		documentID, err := strconv.ParseInt(emoji.DocumentID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при парсинге id документа: %v", err)
 		}
+		formats = append(formats, styling.CustomEmoji("⭐️", documentID))
 		if math.Mod(float64(i+1), float64(width)) == 0 {
 			formats = append(formats, styling.Plain("\n"))
 		}
@@ -0,0 +1,31 @@
+package userbot
+
+import (
+	"emoji-generator/bots"
+	"log/slog"
+
+	"github.com/celestix/gotgproto/ext"
+)
+
+func (u *User) cancel(ctx *ext.Context, update *ext.Update) error {
+	if !update.EffectiveChat().IsAUser() {
+		return nil
+	}
+	userID := update.EffectiveChat().GetID()
+
+	// Генерации идут очередью задач ботов, в том числе команды из личных сообщений userbot
+	canceled, err := bots.CancelUserJobs(ctx, userID)
+	if err != nil {
+		slog.Error("Failed to cancel jobs", slog.Int64("user_id", userID), slog.String("err", err.Error()))
+		u.sendMessageByBot(ctx, update, "Не удалось отменить генерацию. Попробуйте позже")
+		return err
+	}
+
+	if canceled == 0 {
+		u.sendMessageByBot(ctx, update, "Нет активных генераций")
+		return nil
+	}
+
+	u.sendMessageByBot(ctx, update, "Генерация отменена")
+	return nil
+}
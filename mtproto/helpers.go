@@ -2,11 +2,11 @@ package userbot
 
 import (
 	"context"
-	"emoji-generator/db"
 	"emoji-generator/types"
 	"fmt"
 	"github.com/celestix/gotgproto/ext"
 	"github.com/celestix/gotgproto/functions"
+	"github.com/gotd/td/telegram/downloader"
 	"github.com/gotd/td/telegram/message"
 	"github.com/gotd/td/tg"
 	"log/slog"
@@ -29,65 +29,107 @@ func (u *User) getReplyMessage(ctx *ext.Context, chatID int64, replyMsgID int) (
 	return msg, nil
 }
 
-func (u *User) downloadMedia(ctx *ext.Context, update *ext.Update, workingDir string) (string, error) {
+// sourceMedia находит файл команды в самом сообщении или в сообщении, на которое
+// оно отвечает. Возвращает файл и id сообщения, в котором он лежит.
+func (u *User) sourceMedia(ctx *ext.Context, update *ext.Update) (tg.MessageMediaClass, int, error) {
+	if media, ok := update.EffectiveMessage.GetMedia(); ok {
+		return media, update.EffectiveMessage.ID, nil
+	}
 
-	if err := os.MkdirAll(workingDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create working directory: %w", err)
+	if update.EffectiveMessage.ReplyTo == nil || !strings.Contains(update.EffectiveMessage.ReplyTo.String(), "ReplyToMsgID:") {
+		return nil, 0, types.ErrFileNotProvided
 	}
 
-	var media tg.MessageMediaClass
-	var ok bool
-	media, ok = update.EffectiveMessage.GetMedia()
-	if !ok {
-		if update.EffectiveMessage.ReplyTo != nil {
-
-			if strings.Contains(update.EffectiveMessage.ReplyTo.String(), "ReplyToMsgID:") {
-				replySlice := strings.Split(update.EffectiveMessage.ReplyTo.String(), " ")
-				replyMsgID := 0
-				for _, m := range replySlice {
-					if strings.Contains(m, "ReplyToMsgID:") {
-						var err error
-						replyMsgID, err = strconv.Atoi(strings.Split(m, ":")[1])
-						if err != nil {
-							return "", fmt.Errorf("ошибка при парсинге id сообщения: %v", err)
-						}
-					}
-				}
-
-				var err error
-				replyMsg, err := u.getReplyMessage(ctx, update.EffectiveChat().GetID(), replyMsgID)
-				if err != nil {
-					return "", err
-				}
-
-				media, ok = replyMsg.GetMedia()
-				if !ok {
-					return "", types.ErrFileNotProvided
-				}
-
-				media.TypeName()
-			} else {
-				return "", types.ErrFileNotProvided
+	replySlice := strings.Split(update.EffectiveMessage.ReplyTo.String(), " ")
+	replyMsgID := 0
+	for _, m := range replySlice {
+		if strings.Contains(m, "ReplyToMsgID:") {
+			var err error
+			replyMsgID, err = strconv.Atoi(strings.Split(m, ":")[1])
+			if err != nil {
+				return nil, 0, fmt.Errorf("ошибка при парсинге id сообщения: %v", err)
 			}
 		}
+	}
+
+	replyMsg, err := u.getReplyMessage(ctx, update.EffectiveChat().GetID(), replyMsgID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	media, ok := replyMsg.GetMedia()
+	if !ok {
+		return nil, 0, types.ErrFileNotProvided
+	}
+	return media, replyMsgID, nil
+}
 
+// saveMedia скачивает файл сообщения в workingDir
+func (u *User) saveMedia(ctx context.Context, media tg.MessageMediaClass, workingDir string) (string, error) {
+	if err := os.MkdirAll(workingDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create working directory: %w", err)
 	}
 
 	filename, err := GetMediaFileNameWithId(media)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при получении имени файла: %v", err)
+		return "", fmt.Errorf("ошибка при получении имени файла: %v", err)
 	}
 
-	_, err = ctx.DownloadMedia(
-		media,
-		ext.DownloadOutputPath(workingDir+"/"+filename),
-		nil,
-	)
+	location, err := functions.GetInputFileLocation(media)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при скачивании файла: %v", err)
+		return "", fmt.Errorf("ошибка при получении файла: %v", err)
+	}
+
+	path := workingDir + "/" + filename
+	if _, err := downloader.NewDownloader().Download(u.client.API(), location).ToPath(ctx, path); err != nil {
+		return "", fmt.Errorf("ошибка при скачивании файла: %v", err)
 	}
 
-	return workingDir + "/" + filename, nil
+	return path, nil
+}
+
+// DownloadSource заново скачивает исходный файл команды из личных сообщений
+// userbot в workingDir, source записан types.EmojiCommand.SetUserbotSource
+func (u *User) DownloadSource(ctx context.Context, source string, workingDir string) (string, error) {
+	chatID, msgID, ok := types.ParseUserbotSource(source)
+	if !ok {
+		return "", fmt.Errorf("invalid userbot source %q", source)
+	}
+
+	messages, err := functions.GetMessages(ctx, u.client.API(), u.client.PeerStorage, chatID, []tg.InputMessageClass{&tg.InputMessageID{ID: msgID}})
+	if err != nil {
+		return "", fmt.Errorf("get messages: %w", err)
+	}
+	if len(messages) == 0 {
+		return "", types.ErrFileNotProvided
+	}
+
+	msg := functions.GetMessageFromMessageClass(messages[0])
+	if msg == nil {
+		return "", types.ErrFileNotProvided
+	}
+	media, ok := msg.GetMedia()
+	if !ok {
+		return "", types.ErrFileNotProvided
+	}
+	return u.saveMedia(ctx, media, workingDir)
+}
+
+// mediaMimeType тип исходного файла. Фото Telegram всегда хранит в JPEG.
+func mediaMimeType(media tg.MessageMediaClass) string {
+	switch v := media.(type) {
+	case *tg.MessageMediaPhoto:
+		return "image/jpeg"
+	case *tg.MessageMediaDocument:
+		if f, ok := v.Document.AsNotEmpty(); ok {
+			return f.MimeType
+		}
+	case *tg.MessageMediaStory:
+		if story, ok := v.Story.(*tg.StoryItem); ok {
+			return mediaMimeType(story.Media)
+		}
+	}
+	return ""
 }
 
 func GetMediaFileNameWithId(media tg.MessageMediaClass) (string, error) {
@@ -147,15 +189,3 @@ func (u *User) sendMessageByBot(ctx *ext.Context, update *ext.Update, text strin
 		slog.Error("Failed to send message by userBot", slog.String("err", err.Error()))
 	}
 }
-
-func (u *User) createDatabaseRecord(args *types.EmojiCommand, initialCommand string, botUsername string) (*db.EmojiPack, error) {
-	emojiPack := &db.EmojiPack{
-		CreatorID:      args.UserID,
-		PackName:       args.SetName,
-		PackLink:       &args.PackLink,
-		InitialCommand: &initialCommand,
-		BotName:        botUsername,
-		EmojiCount:     0,
-	}
-	return db.Postgres.LogEmojiCommand(context.Background(), emojiPack)
-}
@@ -51,9 +51,9 @@ func (u *User) CreateProgressMessage(ctx context.Context, chatID int64, replyTo
 
 	chatStr := strconv.FormatInt(chatID, 10)
 
-	// Сохраняем ID сообщения с составным ключом
+	// Сохраняем текст сообщения с составным ключом
 	key := chatStr + ":" + strconv.Itoa(msgID)
-	u.progressMessages.Store(key, msgID)
+	u.progressMessages.Store(key, text)
 
 	return msgID
 }
@@ -73,11 +73,14 @@ func (u *User) UpdateProgressMessage(ctx context.Context, chatID int64, msgID in
 
 	// Получаем ID сообщения по составному ключу
 	key := chatStr + ":" + strconv.Itoa(msgID)
-	_, ok := u.progressMessages.Load(key)
+	current, ok := u.progressMessages.Load(key)
 	if !ok {
 		slog.Warn("Progress message not found in storage",
 			slog.Int64("chatID", chatID),
 			slog.Int("msgID", msgID))
+	} else if current == text {
+		// Telegram отклоняет редактирование без изменений
+		return
 	}
 
 	sender := message.NewSender(tg.NewClient(u.client))
@@ -92,8 +95,11 @@ func (u *User) UpdateProgressMessage(ctx context.Context, chatID int64, msgID in
 			slog.String("err", err.Error()),
 			slog.Int64("chatID", chatID),
 			slog.Int("msgID", msgID))
+		return
+	}
+	if ok {
+		u.progressMessages.Store(key, text)
 	}
-
 }
 
 // DeleteProgressMessage удаляет сообщение о прогрессе
@@ -3,6 +3,8 @@ package bot
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 )
 
 var (
@@ -23,9 +25,13 @@ func (e *TooManyRequestsError) Error() string {
 	return fmt.Sprintf("%s: retry_after %d", e.Message, e.RetryAfter)
 }
 
+func (e *TooManyRequestsError) Unwrap() error {
+	return ErrorTooManyRequests
+}
+
 func IsTooManyRequestsError(err error) bool {
-	_, ok := err.(*TooManyRequestsError)
-	return ok
+	var target *TooManyRequestsError
+	return errors.As(err, &target)
 }
 
 type MigrateError struct {
@@ -37,7 +43,100 @@ func (e *MigrateError) Error() string {
 	return fmt.Sprintf("%s: migrate_to_chat_id %d", e.Message, e.MigrateToChatID)
 }
 
+func (e *MigrateError) Unwrap() error {
+	return ErrorBadRequest
+}
+
 func IsMigrateError(err error) bool {
-	_, ok := err.(*MigrateError)
-	return ok
+	var target *MigrateError
+	return errors.As(err, &target)
+}
+
+// ResponseParameters describes why a request was unsuccessful
+type ResponseParameters struct {
+	RetryAfter      int `json:"retry_after,omitempty"`
+	MigrateToChatID int `json:"migrate_to_chat_id,omitempty"`
+}
+
+// APIError is returned by every request that Telegram answered with ok=false.
+// It unwraps to the matching sentinel (ErrorBadRequest, ErrorForbidden, ...)
+// and, where applicable, to TooManyRequestsError or MigrateError.
+type APIError struct {
+	Method          string
+	Code            int
+	Description     string
+	DescriptionCode string // e.g. STICKERSET_INVALID, empty if the description has no code
+	Parameters      ResponseParameters
+
+	err error
+}
+
+func newAPIError(method string, code int, description string, parameters ResponseParameters) *APIError {
+	e := &APIError{
+		Method:          method,
+		Code:            code,
+		Description:     description,
+		DescriptionCode: descriptionCode(description),
+		Parameters:      parameters,
+	}
+
+	switch code {
+	case 403:
+		e.err = fmt.Errorf("%w, %s", ErrorForbidden, description)
+	case 400:
+		if parameters.MigrateToChatID != 0 {
+			e.err = &MigrateError{
+				Message:         fmt.Sprintf("%s: %s", ErrorBadRequest, description),
+				MigrateToChatID: parameters.MigrateToChatID,
+			}
+			break
+		}
+		e.err = fmt.Errorf("%w, %s", ErrorBadRequest, description)
+	case 401:
+		e.err = fmt.Errorf("%w, %s", ErrorUnauthorized, description)
+	case 404:
+		e.err = fmt.Errorf("%w, %s", ErrorNotFound, description)
+	case 409:
+		e.err = fmt.Errorf("%w, %s", ErrorConflict, description)
+	case 429:
+		e.err = &TooManyRequestsError{
+			Message:    fmt.Sprintf("%s, %s", ErrorTooManyRequests, description),
+			RetryAfter: parameters.RetryAfter,
+		}
+	}
+
+	return e
+}
+
+func (e *APIError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("error response from telegram for method %s, %d %s", e.Method, e.Code, e.Description)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+var descriptionCodeRe = regexp.MustCompile(`\b[A-Z][A-Z0-9]*(?:_[A-Z0-9]+)+\b`)
+
+// descriptionCode extracts the machine-readable code from a description
+// like "Bad Request: STICKERSET_INVALID"
+func descriptionCode(description string) string {
+	return descriptionCodeRe.FindString(description)
+}
+
+// IsDescription reports whether err is an APIError whose description code
+// equals desc, or whose description contains desc (case-insensitive).
+// The latter covers descriptions without a code, like "bot was blocked by the user".
+func IsDescription(err error, desc string) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || desc == "" {
+		return false
+	}
+	if apiErr.DescriptionCode == desc {
+		return true
+	}
+	return strings.Contains(strings.ToLower(apiErr.Description), strings.ToLower(desc))
 }
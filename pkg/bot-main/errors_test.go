@@ -2,6 +2,7 @@ package bot
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -80,3 +81,68 @@ func TestIsMigrateErrorFalse(t *testing.T) {
 		t.Errorf("expected IsMigrateError to return false")
 	}
 }
+
+func TestAPIError(t *testing.T) {
+	err := error(newAPIError("addStickerToSet", 400, "Bad Request: STICKERSET_INVALID", ResponseParameters{}))
+
+	if err.Error() != "bad request, Bad Request: STICKERSET_INVALID" {
+		t.Errorf("unexpected error message: %s", err.Error())
+	}
+	if !errors.Is(err, ErrorBadRequest) {
+		t.Errorf("expected APIError to unwrap to ErrorBadRequest")
+	}
+
+	var apiErr *APIError
+	if !errors.As(fmt.Errorf("add sticker: %w", err), &apiErr) {
+		t.Fatalf("expected wrapped error to be APIError")
+	}
+	if apiErr.Code != 400 || apiErr.DescriptionCode != "STICKERSET_INVALID" {
+		t.Errorf("unexpected APIError fields: %+v", apiErr)
+	}
+
+	if !IsDescription(err, "STICKERSET_INVALID") {
+		t.Errorf("expected IsDescription to match description code")
+	}
+	if IsDescription(err, "STICKER_VIDEO_BIG") {
+		t.Errorf("expected IsDescription not to match another code")
+	}
+	if IsDescription(errors.New("STICKERSET_INVALID"), "STICKERSET_INVALID") {
+		t.Errorf("expected IsDescription to ignore non-API errors")
+	}
+}
+
+func TestAPIErrorWithoutCode(t *testing.T) {
+	err := newAPIError("sendMessage", 403, "Forbidden: bot was blocked by the user", ResponseParameters{})
+
+	if err.DescriptionCode != "" {
+		t.Errorf("expected empty description code, got %s", err.DescriptionCode)
+	}
+	if !errors.Is(err, ErrorForbidden) {
+		t.Errorf("expected APIError to unwrap to ErrorForbidden")
+	}
+	if !IsDescription(err, "bot was blocked by the user") {
+		t.Errorf("expected IsDescription to match description text")
+	}
+}
+
+func TestAPIErrorParameters(t *testing.T) {
+	flood := newAPIError("uploadStickerFile", 429, "Too Many Requests: retry after 5", ResponseParameters{RetryAfter: 5})
+	if !IsTooManyRequestsError(flood) || !errors.Is(flood, ErrorTooManyRequests) {
+		t.Errorf("expected APIError to unwrap to TooManyRequestsError")
+	}
+
+	var tooMany *TooManyRequestsError
+	if !errors.As(flood, &tooMany) || tooMany.RetryAfter != 5 {
+		t.Errorf("unexpected retry_after: %+v", tooMany)
+	}
+
+	migrate := newAPIError("sendMessage", 400, "Bad Request: group chat was upgraded to a supergroup chat", ResponseParameters{MigrateToChatID: 42})
+	if !IsMigrateError(migrate) || !errors.Is(migrate, ErrorBadRequest) {
+		t.Errorf("expected APIError to unwrap to MigrateError")
+	}
+
+	unknown := newAPIError("foo", 500, "Internal Server Error", ResponseParameters{})
+	if unknown.Error() != "error response from telegram for method foo, 500 Internal Server Error" {
+		t.Errorf("unexpected error message: %s", unknown.Error())
+	}
+}
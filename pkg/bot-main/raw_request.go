@@ -14,14 +14,11 @@ import (
 )
 
 type apiResponse struct {
-	OK          bool            `json:"ok"`
-	Result      json.RawMessage `json:"result,omitempty"`
-	Description string          `json:"description,omitempty"`
-	ErrorCode   int             `json:"error_code,omitempty"`
-	Parameters  struct {
-		RetryAfter      int `json:"retry_after,omitempty"`
-		MigrateToChatID int `json:"migrate_to_chat_id,omitempty"`
-	} `json:"parameters,omitempty"`
+	OK          bool               `json:"ok"`
+	Result      json.RawMessage    `json:"result,omitempty"`
+	Description string             `json:"description,omitempty"`
+	ErrorCode   int                `json:"error_code,omitempty"`
+	Parameters  ResponseParameters `json:"parameters,omitempty"`
 }
 
 func (b *Bot) rawRequest(ctx context.Context, method string, params any, dest any) error {
@@ -95,34 +92,7 @@ func (b *Bot) rawRequest(ctx context.Context, method string, params any, dest an
 	}
 
 	if !r.OK {
-		switch r.ErrorCode {
-		case 403:
-			return fmt.Errorf("%w, %s", ErrorForbidden, r.Description)
-		case 400:
-			if r.Parameters.MigrateToChatID != 0 {
-				err := &MigrateError{
-					Message:         fmt.Sprintf("%s: %s", ErrorBadRequest, r.Description),
-					MigrateToChatID: r.Parameters.MigrateToChatID,
-				}
-
-				return err
-			}
-			return fmt.Errorf("%w, %s", ErrorBadRequest, r.Description)
-		case 401:
-			return fmt.Errorf("%w, %s", ErrorUnauthorized, r.Description)
-		case 404:
-			return fmt.Errorf("%w, %s", ErrorNotFound, r.Description)
-		case 409:
-			return fmt.Errorf("%w, %s", ErrorConflict, r.Description)
-		case 429:
-			err := &TooManyRequestsError{
-				Message:    fmt.Sprintf("%s, %s", ErrorTooManyRequests, r.Description),
-				RetryAfter: r.Parameters.RetryAfter,
-			}
-			return err
-		default:
-			return fmt.Errorf("error response from telegram for method %s, %d %s", method, r.ErrorCode, r.Description)
-		}
+		return newAPIError(method, r.ErrorCode, r.Description, r.Parameters)
 	}
 
 	if !bytes.Equal(r.Result, []byte("[]")) {
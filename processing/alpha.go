@@ -0,0 +1,90 @@
+package processing
+
+import (
+	"context"
+	"emoji-generator/types"
+	"log/slog"
+	"path/filepath"
+)
+
+// alphaThreshold непрозрачность, выше которой пиксель считается видимым.
+// Запас нужен из-за шума сжатия альфа-канала.
+const alphaThreshold = 8
+
+// EmptyTiles возвращает для каждого тайла, что на всех его кадрах нет ни одного
+// видимого пикселя. Тайлы целиком вне видео пусты по геометрии сетки. Остальные
+// могут опустеть только от фона, удаленного colorkey, поэтому их альфа-канал
+// декодируется лишь с параметром background. Пустые тайлы заменяются общим
+// прозрачным разделителем вместо загрузки в пак.
+func EmptyTiles(ctx context.Context, args *types.EmojiCommand, files []string) ([]bool, error) {
+	width, height, err := getVideoDimensions(ctx, args.DownloadedFile)
+	if err != nil {
+		return nil, err
+	}
+	empty := gridEmptyTiles(newTileGrid(args, width, height), len(files))
+
+	if args.BackgroundColor == "" {
+		return empty, nil
+	}
+	for i, file := range files {
+		if empty[i] {
+			continue
+		}
+		visible, err := tileVisible(ctx, args, file)
+		if err != nil {
+			return nil, err
+		}
+		empty[i] = !visible
+	}
+	return empty, nil
+}
+
+// gridEmptyTiles отмечает тайлы сетки, которые не пересекаются с видео
+func gridEmptyTiles(grid tileGrid, count int) []bool {
+	empty := make([]bool, count)
+	if grid.Cols <= 0 {
+		return empty
+	}
+	for i := range empty {
+		empty[i] = grid.tileRect(i/grid.Cols, i%grid.Cols).Intersect(grid.Content).Empty()
+	}
+	return empty
+}
+
+// tileVisible декодирует альфа-канал тайла по всем кадрам. Если альфа-канал
+// прочитать не удалось, тайл считается видимым: пустой пак хуже лишнего стикера.
+func tileVisible(ctx context.Context, args *types.EmojiCommand, file string) (bool, error) {
+	frames, err := runScheduledFFmpeg(ctx, userRequest(args, 1), StageAlpha, AlphaTimeout,
+		// Встроенный декодер vp9 не читает альфа-канал webm, нужен libvpx
+		"-c:v", "libvpx-vp9",
+		"-i", file,
+		"-vf", "alphaextract",
+		"-pix_fmt", "gray",
+		"-f", "rawvideo",
+		"pipe:1",
+	)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, err
+		}
+		slog.Warn("alpha analysis failed, keeping tile",
+			slog.String("file", filepath.Base(file)),
+			slog.String("err", err.Error()))
+		return true, nil
+	}
+	if len(frames) == 0 {
+		return true, nil
+	}
+
+	return hasVisiblePixels(frames), nil
+}
+
+// hasVisiblePixels проверяет кадры альфа-канала в формате gray
+func hasVisiblePixels(alpha []byte) bool {
+	for _, a := range alpha {
+		if a > alphaThreshold {
+			return true
+		}
+	}
+	return false
+}
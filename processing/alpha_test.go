@@ -0,0 +1,26 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlpha_HasVisiblePixels(t *testing.T) {
+	frame := make([]byte, 100*100*10)
+	assert.False(t, hasVisiblePixels(frame))
+
+	// Шум сжатия альфа-канала видимым не считается
+	frame[500] = alphaThreshold
+	assert.False(t, hasVisiblePixels(frame))
+
+	frame[len(frame)-1] = 255
+	assert.True(t, hasVisiblePixels(frame))
+}
+
+func TestAlpha_GridEmptyTiles(t *testing.T) {
+	// Видео 200x120 на сетке 2x3, прижато к верху: нижний ряд пуст
+	grid := newTileGrid(&types.EmojiCommand{Width: 2, Height: 3, Fit: types.FitContain}, 200, 120)
+	assert.Equal(t, []bool{false, false, false, false, true, true}, gridEmptyTiles(grid, 6))
+}
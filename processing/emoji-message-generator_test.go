@@ -0,0 +1,24 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateEmojiMessage_SpacerAndEmptyCells(t *testing.T) {
+	spacer := types.EmojiMeta{DocumentID: "1", Transparent: true}
+	rows := [][]types.EmojiMeta{
+		{spacer, {DocumentID: "10"}, {DocumentID: "11"}, spacer},
+		{spacer, {DocumentID: "12"}, {}, spacer},
+	}
+
+	emojis := GenerateEmojiMessage(rows)
+
+	ids := make([]string, 0, len(emojis))
+	for _, emoji := range emojis {
+		ids = append(ids, emoji.DocumentID)
+	}
+	assert.Equal(t, []string{"1", "10", "11", "1", "1", "12", "1"}, ids)
+}
@@ -0,0 +1,222 @@
+package processing
+
+import (
+	"context"
+	"emoji-generator/types"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// tileDuration длительность тайла в секундах, если она не задана параметром dur
+	tileDuration = types.MaxEmojiDuration
+
+	// minTileBitrate нижняя граница поиска битрейта, бит/с
+	minTileBitrate = 16_000
+
+	// maxSearchSteps ограничивает число пробных кодирований одного тайла
+	maxSearchSteps = 6
+
+	// containerOverhead доля бюджета, которая остается на видеопоток после заголовков WebM
+	containerOverhead = 0.92
+)
+
+// MaxReencodeAttempts сколько раз тайл может быть перекодирован после отказа Telegram
+const MaxReencodeAttempts = 3
+
+// encodingProfile описывает целевое качество и бюджет размера одного тайла
+type encodingProfile struct {
+	CRF    int
+	Budget int64 // целевой размер тайла в байтах
+}
+
+var qualityProfiles = map[string]encodingProfile{
+	types.QualityHigh:     {CRF: 18, Budget: types.MaxEmojiFileSize * 95 / 100},
+	types.QualityBalanced: {CRF: 24, Budget: types.MaxEmojiFileSize * 70 / 100},
+	types.QualitySmall:    {CRF: 32, Budget: types.MaxEmojiFileSize * 40 / 100},
+}
+
+func profileFor(quality string) encodingProfile {
+	if profile, ok := qualityProfiles[quality]; ok {
+		return profile
+	}
+	return qualityProfiles[types.DefaultQuality]
+}
+
+// IsQualityProfile проверяет, что профиль качества существует
+func IsQualityProfile(quality string) bool {
+	_, ok := qualityProfiles[quality]
+	return ok
+}
+
+// budgetBitrate переводит бюджет размера в битрейт видеопотока, бит/с
+func budgetBitrate(budget int64, duration float64) int {
+	return int(float64(budget*8) / duration * containerOverhead)
+}
+
+// tileEncoderArgs возвращает параметры VP9-кодировщика для одного выходного тайла.
+// CRF задает качество, а -b:v ограничивает битрейт сверху (constrained quality).
+func tileEncoderArgs(profile encodingProfile, bitrate int) []string {
+	return []string{
+		"-c:v", "libvpx-vp9",
+		"-profile:v", "0",
+		"-pix_fmt", "yuva420p",
+		"-crf", fmt.Sprintf("%d", profile.CRF),
+		"-b:v", fmt.Sprintf("%d", bitrate),
+		"-auto-alt-ref", "1",
+		"-metadata:s:v:0", "alpha_mode=1",
+		"-an",
+	}
+}
+
+// encodeTile кодирует один тайл сетки из подготовленного видео в output
+func encodeTile(ctx context.Context, args *types.EmojiCommand, grid tileGrid, position int, profile encodingProfile, bitrate int, output string) error {
+	row, col := position/grid.Cols, position%grid.Cols
+
+	ffmpegArgs := []string{
+		"-y",
+		"-t", fmt.Sprintf("%.3f", clipDuration(args)),
+		"-i", args.DownloadedFile,
+		"-vf", fmt.Sprintf("fps=%d,%s", clipFPS(args), tileFilter(args, grid, row, col)),
+	}
+	ffmpegArgs = append(ffmpegArgs, tileEncoderArgs(profile, bitrate)...)
+	ffmpegArgs = append(ffmpegArgs,
+		"-maxrate", fmt.Sprintf("%d", bitrate),
+		"-bufsize", fmt.Sprintf("%d", bitrate*2),
+		output,
+	)
+
+	if err := runFFmpeg(ctx, args, 1, StageEncode, EncodeTimeout, ffmpegArgs...); err != nil {
+		return fmt.Errorf("ошибка при кодировании тайла %s: %w", filepath.Base(output), err)
+	}
+	return nil
+}
+
+// fitTile ищет бинарным поиском наибольший битрейт, при котором тайл укладывается
+// в бюджет, и перезаписывает файл лучшим найденным вариантом
+func fitTile(ctx context.Context, args *types.EmojiCommand, grid tileGrid, files []string, position int, profile encodingProfile, budget int64) error {
+	if position < 0 || position >= grid.Count() || position >= len(files) {
+		return fmt.Errorf("тайл %d вне сетки %dx%d", position, grid.Cols, grid.Rows)
+	}
+
+	file := files[position]
+	candidate := strings.TrimSuffix(file, ".webm") + ".fit.webm"
+	defer os.Remove(candidate)
+
+	try := func(bitrate int) (bool, error) {
+		if err := encodeTile(ctx, args, grid, position, profile, bitrate, candidate); err != nil {
+			return false, err
+		}
+		info, err := os.Stat(candidate)
+		if err != nil {
+			return false, err
+		}
+		if info.Size() > budget {
+			return false, nil
+		}
+		return true, os.Rename(candidate, file)
+	}
+
+	// Сначала пробуем верхнюю границу: чаще всего она и подходит
+	lo, hi := minTileBitrate, budgetBitrate(budget, clipDuration(args))
+	fits, err := try(hi)
+	if err != nil || fits {
+		return err
+	}
+
+	found := false
+	hi--
+	for step := 1; step < maxSearchSteps && lo <= hi; step++ {
+		mid := (lo + hi) / 2
+		fits, err := try(mid)
+		if err != nil {
+			return err
+		}
+		if fits {
+			found = true
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	if found {
+		return nil
+	}
+
+	fits, err = try(minTileBitrate)
+	if err != nil {
+		return err
+	}
+	if !fits {
+		return fmt.Errorf("%w: %s", types.ErrTileTooBig, filepath.Base(file))
+	}
+	return nil
+}
+
+// OversizedTiles возвращает позиции тайлов, размер которых превышает budget
+func OversizedTiles(files []string, budget int64) ([]int, error) {
+	var positions []int
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("stat tile %s: %w", filepath.Base(file), err)
+		}
+		if info.Size() > budget {
+			positions = append(positions, i)
+		}
+	}
+	return positions, nil
+}
+
+// ShrinkOversizedTiles проверяет размер каждого тайла и перекодирует только те,
+// что не укладываются в бюджет выбранного профиля качества
+func ShrinkOversizedTiles(ctx context.Context, args *types.EmojiCommand, files []string) error {
+	profile := profileFor(args.Quality)
+	oversized, err := OversizedTiles(files, profile.Budget)
+	if err != nil {
+		return err
+	}
+	if len(oversized) == 0 {
+		return nil
+	}
+
+	width, height, err := getVideoDimensions(ctx, args.DownloadedFile)
+	if err != nil {
+		return err
+	}
+	grid := newTileGrid(args, width, height)
+
+	log.Printf("Re-encoding %d oversized tiles to fit %d KB", len(oversized), profile.Budget/1024)
+	for _, position := range oversized {
+		if err := fitTile(ctx, args, grid, files, position, profile, profile.Budget); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShrinkTile перекодирует один тайл, отклоненный Telegram, в бюджет на четверть
+// меньше его текущего размера
+func ShrinkTile(ctx context.Context, args *types.EmojiCommand, files []string, position int) error {
+	if position < 0 || position >= len(files) {
+		return fmt.Errorf("тайл %d вне списка из %d файлов", position, len(files))
+	}
+
+	info, err := os.Stat(files[position])
+	if err != nil {
+		return fmt.Errorf("stat tile %s: %w", filepath.Base(files[position]), err)
+	}
+
+	profile := profileFor(args.Quality)
+	budget := min(info.Size()*3/4, profile.Budget)
+
+	width, height, err := getVideoDimensions(ctx, args.DownloadedFile)
+	if err != nil {
+		return err
+	}
+
+	return fitTile(ctx, args, newTileGrid(args, width, height), files, position, profile, budget)
+}
@@ -0,0 +1,130 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"emoji-generator/scheduler"
+	"emoji-generator/types"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Этапы обработки, по которым различаются ошибки и таймауты
+const (
+	StageProbe  = "probe"
+	StageResize = "resize"
+	StageTiles  = "tiles"
+	StageEncode = "encode"
+	StageAlpha  = "alpha"
+	StageScenes = "scenes"
+)
+
+// Таймауты этапов обработки. Этап прерывается раньше, если отменен контекст запроса.
+var (
+	ProbeTimeout  = 30 * time.Second
+	ResizeTimeout = 2 * time.Minute
+	TilesTimeout  = 5 * time.Minute
+	EncodeTimeout = time.Minute
+	AlphaTimeout  = 30 * time.Second
+	ScenesTimeout = 2 * time.Minute
+)
+
+// stderrTailLines сколько последних строк stderr сохраняется в ошибке
+const stderrTailLines = 5
+
+// FFmpegError ошибка запуска ffmpeg/ffprobe с сообщением самого процесса
+type FFmpegError struct {
+	Stage  string
+	Err    error
+	Stderr string
+}
+
+func (e *FFmpegError) Error() string {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return fmt.Sprintf("%s: превышено время ожидания", e.Stage)
+	}
+	if errors.Is(e.Err, context.Canceled) {
+		return fmt.Sprintf("%s: обработка отменена", e.Stage)
+	}
+	if e.Stderr != "" {
+		return fmt.Sprintf("%s: %s", e.Stage, e.Stderr)
+	}
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// runCommand запускает процесс, привязанный к ctx и таймауту этапа, и возвращает его stdout.
+// При отмене контекста процесс убивается.
+func runCommand(ctx context.Context, stage string, timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = 5 * time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, &FFmpegError{
+			Stage:  stage,
+			Err:    err,
+			Stderr: tailLines(stderr.String(), stderrTailLines),
+		}
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// runFFmpeg ждет слотов в общем планировщике ffmpeg и запускает процесс.
+// Таймаут этапа отсчитывается с момента запуска, а не постановки в очередь.
+func runFFmpeg(ctx context.Context, emojiArgs *types.EmojiCommand, cost int, stage string, timeout time.Duration, args ...string) error {
+	_, err := runScheduledFFmpeg(ctx, userRequest(emojiArgs, cost), stage, timeout, args...)
+	return err
+}
+
+func userRequest(emojiArgs *types.EmojiCommand, cost int) scheduler.Request {
+	return scheduler.Request{
+		UserID: emojiArgs.UserID,
+		Vip:    emojiArgs.Permissions.Vip,
+		Cost:   cost,
+	}
+}
+
+// runScheduledFFmpeg то же, что runFFmpeg, но возвращает stdout процесса
+func runScheduledFFmpeg(ctx context.Context, req scheduler.Request, stage string, timeout time.Duration, args ...string) ([]byte, error) {
+	release, err := scheduler.FFmpeg.Acquire(ctx, req)
+	if err != nil {
+		return nil, &FFmpegError{Stage: stage, Err: err}
+	}
+	defer release()
+
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-nostdin"}, args...)
+	return runCommand(ctx, stage, timeout, "ffmpeg", args...)
+}
+
+// tilesCost оценивает, сколько слотов CPU занимает проход нарезки на count тайлов
+func tilesCost(count int) int {
+	return 1 + count/16
+}
+
+func runFFprobe(ctx context.Context, args ...string) ([]byte, error) {
+	return runCommand(ctx, StageProbe, ProbeTimeout, "ffprobe", args...)
+}
+
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
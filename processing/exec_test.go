@@ -0,0 +1,35 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExec_RunCommandStderr(t *testing.T) {
+	_, err := runCommand(context.Background(), StageTiles, time.Second, "sh", "-c", "echo noise >&2; echo 'Invalid data found' >&2; exit 1")
+	require.Error(t, err)
+
+	var ffmpegErr *FFmpegError
+	require.True(t, errors.As(err, &ffmpegErr))
+	assert.Equal(t, StageTiles, ffmpegErr.Stage)
+	assert.Equal(t, "noise\nInvalid data found", ffmpegErr.Stderr)
+	assert.Contains(t, err.Error(), "Invalid data found")
+}
+
+func TestExec_RunCommandTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := runCommand(context.Background(), StageResize, 100*time.Millisecond, "sleep", "5")
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = runCommand(ctx, StageResize, time.Second, "sleep", "5")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
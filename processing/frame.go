@@ -0,0 +1,86 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"fmt"
+	"math"
+)
+
+// frame описывает, как исходное видео готовится к нарезке на сетку Cols x Rows:
+// после фильтра Filter видео имеет размер Width x Height и не больше сетки.
+// Где оно лежит на сетке, определяет newTileGrid.
+type frame struct {
+	Cols, Rows    int
+	Width, Height int
+	Filter        string
+}
+
+// planFrame рассчитывает сетку и подготовку видео srcWidth x srcHeight по параметрам
+// команды: ширина w задает число столбцов, h - число рядов (по умолчанию по
+// пропорциям видео), fit - как видео вписывается в сетку.
+func planFrame(srcWidth, srcHeight int, args *types.EmojiCommand) frame {
+	f := frame{Cols: args.Width, Rows: args.Height}
+	if f.Cols <= 0 {
+		f.Cols = max(1, srcWidth/tileSize)
+	}
+	gridWidth := f.Cols * tileSize
+
+	if f.Rows <= 0 {
+		height := float64(srcHeight)
+		if args.Fit != types.FitCrop {
+			height = height * float64(gridWidth) / float64(srcWidth)
+		}
+		f.Rows = max(1, int(math.Ceil(height/tileSize)))
+	}
+	gridHeight := f.Rows * tileSize
+	anchor := args.FrameAnchor()
+
+	scaleX := float64(gridWidth) / float64(srcWidth)
+	scaleY := float64(gridHeight) / float64(srcHeight)
+
+	// По горизонтали видео всегда центрируется, anchor задает положение по вертикали
+	switch args.Fit {
+	case types.FitStretch:
+		f.Width, f.Height = gridWidth, gridHeight
+		f.Filter = fmt.Sprintf("scale=%d:%d", f.Width, f.Height)
+	case types.FitCover:
+		scale := max(scaleX, scaleY)
+		scaledWidth := max(gridWidth, evenDimension(float64(srcWidth)*scale))
+		scaledHeight := max(gridHeight, evenDimension(float64(srcHeight)*scale))
+		f.Width, f.Height = gridWidth, gridHeight
+		f.Filter = fmt.Sprintf("scale=%d:%d,crop=%d:%d:%d:%d",
+			scaledWidth, scaledHeight, f.Width, f.Height,
+			anchorOffset(types.AnchorCenter, scaledWidth-f.Width), anchorOffset(anchor, scaledHeight-f.Height))
+	case types.FitCrop:
+		f.Width = min(gridWidth, evenDimension(float64(srcWidth)))
+		f.Height = min(gridHeight, evenDimension(float64(srcHeight)))
+		f.Filter = fmt.Sprintf("crop=%d:%d:%d:%d",
+			f.Width, f.Height,
+			anchorOffset(types.AnchorCenter, srcWidth-f.Width), anchorOffset(anchor, srcHeight-f.Height))
+	default:
+		scale := min(scaleX, scaleY)
+		f.Width = min(gridWidth, evenDimension(float64(srcWidth)*scale))
+		f.Height = min(gridHeight, evenDimension(float64(srcHeight)*scale))
+		f.Filter = fmt.Sprintf("scale=%d:%d", f.Width, f.Height)
+	}
+
+	return f
+}
+
+// anchorOffset возвращает смещение по оси, на которой свободно free пикселей.
+// Смещение четное: VP9 с yuv420p режется только по четным координатам.
+func anchorOffset(anchor string, free int) int {
+	switch anchor {
+	case types.AnchorTop:
+		return 0
+	case types.AnchorBottom:
+		return free &^ 1
+	default:
+		return (free / 2) &^ 1
+	}
+}
+
+// evenDimension округляет размер до четного, но не меньше 2
+func evenDimension(size float64) int {
+	return max(2, int(math.Round(size))&^1)
+}
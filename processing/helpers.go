@@ -5,7 +5,7 @@ import (
 	"emoji-generator/db"
 	"emoji-generator/types"
 	"fmt"
-	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -38,6 +38,12 @@ func SetupEmojiCommand(args *types.EmojiCommand, userID int64, username string)
 	if args.BackgroundBlend == "" {
 		args.BackgroundBlend = defaultBackgroundBlend
 	}
+	if args.Quality == "" {
+		args.Quality = types.DefaultQuality
+	}
+	if args.Fit == "" {
+		args.Fit = types.DefaultFit
+	}
 
 	if args.SetName == "" {
 		args.SetName = strings.TrimSpace(types.PackTitleTempl)
@@ -104,37 +110,8 @@ func ParseArgs(arg string) (*types.EmojiCommand, error) {
 		return &emojiArgs, nil
 	}
 
-	var args []string
-	currentArg := ""
-	inBrackets := false
-
-	arg = strings.ReplaceAll(arg, "\n", " ")
-
-	// Проходим по строке посимвольно для корректной обработки значений в скобках
-	for i := 0; i < len(arg); i++ {
-		switch arg[i] {
-		case '[':
-			inBrackets = true
-		case ']':
-			inBrackets = false
-		case ' ':
-			if !inBrackets {
-				if currentArg != "" {
-					args = append(args, currentArg)
-					currentArg = ""
-				}
-			} else {
-				currentArg += string(arg[i])
-			}
-		default:
-			currentArg += string(arg[i])
-		}
-	}
-	if currentArg != "" {
-		args = append(args, currentArg)
-	}
-
-	for _, arg := range args {
+	startGiven := false
+	for _, arg := range splitArgs(arg) {
 		parts := strings.SplitN(arg, "=", 2)
 		if len(parts) != 2 {
 			continue // Пропускаем несуществующий аргумент
@@ -157,6 +134,65 @@ func ParseArgs(arg string) (*types.EmojiCommand, error) {
 				continue
 			}
 			emojiArgs.Width = width
+		case "height":
+			height, err := strconv.Atoi(value)
+			if err != nil || height < 1 {
+				continue
+			}
+			emojiArgs.Height = height
+		case "fit":
+			value = strings.ToLower(strings.TrimSpace(value))
+			if !slices.Contains([]string{types.FitCover, types.FitContain, types.FitStretch, types.FitCrop}, value) {
+				return &emojiArgs, types.ErrInvalidFit
+			}
+			emojiArgs.Fit = value
+		case "anchor":
+			value = strings.ToLower(strings.TrimSpace(value))
+			if !slices.Contains([]string{types.AnchorCenter, types.AnchorTop, types.AnchorBottom}, value) {
+				return &emojiArgs, types.ErrInvalidAnchor
+			}
+			emojiArgs.Anchor = value
+		case "start":
+			start, err := parseDecimal(value)
+			if err != nil || start < 0 {
+				return &emojiArgs, types.ErrInvalidStart
+			}
+			emojiArgs.Start = start
+			startGiven = true
+		case "duration":
+			duration, err := parseDecimal(value)
+			if err != nil || duration < types.MinEmojiDuration || duration > types.MaxEmojiDuration {
+				return &emojiArgs, types.ErrInvalidDuration
+			}
+			emojiArgs.Duration = duration
+		case "fps":
+			fps, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || fps < 1 || fps > types.MaxEmojiFPS {
+				return &emojiArgs, types.ErrInvalidFPS
+			}
+			emojiArgs.FPS = fps
+		case "speed":
+			speed, err := parseDecimal(value)
+			if err != nil || speed < types.MinSpeed || speed > types.MaxSpeed {
+				return &emojiArgs, types.ErrInvalidSpeed
+			}
+			emojiArgs.Speed = speed
+		case "reverse":
+			if value != "true" && value != "false" {
+				return &emojiArgs, types.ErrInvalidReverse
+			}
+			emojiArgs.Reverse = value == "true"
+		case "loop":
+			value = strings.ToLower(strings.TrimSpace(value))
+			if value != types.LoopBoomerang && value != types.LoopSmooth {
+				return &emojiArgs, types.ErrInvalidLoop
+			}
+			emojiArgs.Loop = value
+		case "auto":
+			if value != "true" && value != "false" {
+				return &emojiArgs, types.ErrInvalidAuto
+			}
+			emojiArgs.Auto = value == "true"
 		case "name":
 			emojiArgs.SetName = strings.TrimSpace(value)
 		case "background":
@@ -174,9 +210,24 @@ func ParseArgs(arg string) (*types.EmojiCommand, error) {
 				continue
 			}
 			emojiArgs.Iphone = value == "true"
+		case "quality":
+			value = strings.ToLower(strings.TrimSpace(value))
+			if !IsQualityProfile(value) {
+				return &emojiArgs, types.ErrInvalidQuality
+			}
+			emojiArgs.Quality = value
 		}
 	}
 
+	if gridTooLarge(emojiArgs.Width, emojiArgs.Height) {
+		return &emojiArgs, types.ErrGridTooLarge
+	}
+
+	// Начало, заданное вручную, важнее автоматического выбора
+	if startGiven {
+		emojiArgs.Auto = false
+	}
+
 	if (emojiArgs.BackgroundSim != "" || emojiArgs.BackgroundBlend != "") && emojiArgs.BackgroundColor == "" {
 		return &emojiArgs, types.ErrInvalidBackgroundArgumentsUse
 	}
@@ -184,6 +235,90 @@ func ParseArgs(arg string) (*types.EmojiCommand, error) {
 	return &emojiArgs, nil
 }
 
+// gridTooLarge проверяет, что сетка cols x rows помещается в один пак. Не заданный
+// размер считается одним тайлом, его проверяют после расчета сетки.
+func gridTooLarge(cols, rows int) bool {
+	cols, rows = max(1, cols), max(1, rows)
+	return cols > types.MaxStickersTotal || rows > types.MaxStickersTotal || cols*rows > types.MaxStickersTotal
+}
+
+// parseDecimal читает дробное число, допуская запятую вместо точки
+func parseDecimal(value string) (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(value), ",", "."), 64)
+}
+
+// splitArgs разбивает строку аргументов на пары param=value, значения в скобках
+// могут содержать пробелы
+func splitArgs(arg string) []string {
+	var args []string
+	currentArg := ""
+	inBrackets := false
+
+	arg = strings.ReplaceAll(arg, "\n", " ")
+
+	// Проходим по строке посимвольно для корректной обработки значений в скобках
+	for i := 0; i < len(arg); i++ {
+		switch arg[i] {
+		case '[':
+			inBrackets = true
+		case ']':
+			inBrackets = false
+		case ' ':
+			if !inBrackets {
+				if currentArg != "" {
+					args = append(args, currentArg)
+					currentArg = ""
+				}
+			} else {
+				currentArg += string(arg[i])
+			}
+		default:
+			currentArg += string(arg[i])
+		}
+	}
+	if currentArg != "" {
+		args = append(args, currentArg)
+	}
+
+	return args
+}
+
+// MergeCommandArgs дополняет аргументы исходной команды base новыми из override:
+// параметр, указанный в override под любым алиасом, заменяет исходный. Пак
+// при перегенерации остается прежним, поэтому link и name из override не берутся.
+func MergeCommandArgs(base, override string) string {
+	var keys []string
+	values := make(map[string]string)
+	add := func(arg string, skip ...string) {
+		for _, a := range splitArgs(arg) {
+			parts := strings.SplitN(a, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			key, exists := types.ArgAlias[strings.ToLower(parts[0])]
+			if !exists || slices.Contains(skip, key) {
+				continue
+			}
+			if _, ok := values[key]; !ok {
+				keys = append(keys, key)
+			}
+			values[key] = parts[1]
+		}
+	}
+	add(base)
+	add(override, "link", "name")
+
+	args := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := values[key]
+		if strings.Contains(value, " ") {
+			value = "[" + value + "]"
+		}
+		args = append(args, key+"="+value)
+	}
+	return strings.Join(args, " ")
+}
+
 func ColorToHex(colorName string) string {
 	if colorName == "" {
 		return ""
@@ -218,7 +353,8 @@ func ColorToHex(colorName string) string {
 	return "0x000000" // возвращаем черный по умолчанию
 }
 
-// validateEmojiFiles проверяет корректность входных файлов
+// validateEmojiFiles проверяет корректность входных файлов. Композиция не больше
+// одного пака, даже если часть тайлов окажется пустой и место в паке не займет.
 func ValidateEmojiFiles(emojiFiles []string) error {
 	if len(emojiFiles) == 0 {
 		return fmt.Errorf("нет файлов для создания набора")
@@ -230,20 +366,3 @@ func ValidateEmojiFiles(emojiFiles []string) error {
 
 	return nil
 }
-
-func PrepareTransparentData(width int) ([]byte, error) {
-	// Подготавливаем прозрачные стикеры если нужно
-	transparentSpacing := types.DefaultWidth - width
-	transparentData, err := os.ReadFile("transparent.webm")
-	if err != nil || transparentSpacing <= 0 {
-		return nil, nil
-	} else if transparentSpacing > 0 {
-		transparentData, err = os.ReadFile("transparent.webm")
-		if err != nil {
-			return nil, fmt.Errorf("open transparent file: %w", err)
-		}
-		return transparentData, nil
-	}
-
-	return nil, nil
-}
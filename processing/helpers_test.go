@@ -1,7 +1,9 @@
 package processing
 
 import (
+	"emoji-generator/types"
 	"encoding/json"
+	"errors"
 	"testing"
 )
 
@@ -23,3 +25,30 @@ b=0XFFFFFF`
 	j, _ := json.MarshalIndent(emojiArgs, "", "  ")
 	t.Log(string(j))
 }
+
+func TestHelpers_MergeCommandArgs(t *testing.T) {
+	merged := MergeCommandArgs("w=6 b=black b_sim=0.2 l=[old link]", "bs=0.3 q=high link=other n=name")
+	if merged != "width=6 background=black background_sim=0.3 link=[old link] quality=high" {
+		t.Errorf("unexpected merged args: %s", merged)
+	}
+
+	emojiArgs, err := ParseArgs(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emojiArgs.Width != 6 || emojiArgs.BackgroundSim != "0.3" || emojiArgs.Quality != "high" || emojiArgs.PackLink != "old link" {
+		t.Errorf("unexpected parsed args: %+v", emojiArgs)
+	}
+}
+
+func TestHelpers_ParseArgsGridLimit(t *testing.T) {
+	for _, arg := range []string{"w=40 h=40", "w=201", "h=201", "w=20 h=11"} {
+		if _, err := ParseArgs(arg); !errors.Is(err, types.ErrGridTooLarge) {
+			t.Errorf("%s: expected ErrGridTooLarge, got %v", arg, err)
+		}
+	}
+
+	if _, err := ParseArgs("w=20 h=10"); err != nil {
+		t.Errorf("w=20 h=10: %v", err)
+	}
+}
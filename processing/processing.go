@@ -1,44 +1,25 @@
 package processing
 
 import (
+	"context"
 	"emoji-generator/types"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 )
 
-func RoundDimensions(width, height int) (int, int) {
-	newWidth := (width / 100) * 100
-	newHeight := int(float64(height) * (float64(newWidth) / float64(width)))
-	if newHeight%2 != 0 {
-		newHeight--
-	}
-	return newWidth, newHeight
-}
-
-func RoundUpTo100(num int) int {
-	return ((num + 99) / 100) * 100
-}
-
-func DimensionToNewWidth(width, height, newWidth int) (int, int) {
-	newHeight := int(float64(height) * (float64(newWidth) / float64(width)))
-	return newWidth, newHeight
-}
-
-func getVideoDimensions(inputVideo string) (width, height int, err error) {
-	cmd := exec.Command("ffprobe",
+func getVideoDimensions(ctx context.Context, inputVideo string) (width, height int, err error) {
+	output, err := runFFprobe(ctx,
 		"-v", "error",
 		"-select_streams", "v:0",
 		"-count_packets",
 		"-show_entries", "stream=width,height",
 		"-of", "csv=p=0",
 		inputVideo)
-
-	output, err := cmd.Output()
 	if err != nil {
 		return 0, 0, err
 	}
@@ -51,190 +32,121 @@ func getVideoDimensions(inputVideo string) (width, height int, err error) {
 	return width, height, nil
 }
 
-type tile struct {
-	OutputFile string
-	FFmpegArgs []string
-	Position   int
-}
-
-type processResult struct {
-	filename string
-	position int
-	err      error
-}
-
-func ProcessVideo(args *types.EmojiCommand) ([]string, error) {
-	width, height, err := getVideoDimensions(args.DownloadedFile)
+// getVideoDuration длительность видео в секундах. У картинок длительности нет,
+// ffprobe выводит N/A, для них 0.
+func getVideoDuration(ctx context.Context, inputVideo string) (float64, error) {
+	output, err := runFFprobe(ctx,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "csv=p=0",
+		inputVideo)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	// Если качество больше 0, значит мы прогоняем видео второй раз из за ошибку STICKER_VIDEO_BIG
-	if args.QualityValue == 0 {
-
-		width, height = RoundDimensions(width, height)
+	value := strings.TrimSpace(string(output))
+	if value == "N/A" {
+		return 0, nil
+	}
+	duration, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse duration %q: %w", value, err)
+	}
+	return duration, nil
+}
 
-		if args.Width != 0 {
-			width, height = DimensionToNewWidth(width, height, args.Width*100)
-		}
-		var i int
-		for i = width; i >= 100; i = i / 100 {
-		}
-		args.Width = i
+// checkSmoothLoop отключает loop=smooth, если после начала отрезка видео не хватает
+// на эмодзи вместе с переходом: переходить было бы не во что. Если длительность
+// узнать не удалось, эмодзи зацикливается без перехода, как при выборе отрезка.
+func checkSmoothLoop(ctx context.Context, args *types.EmojiCommand) error {
+	if args.Loop != types.LoopSmooth {
+		return nil
+	}
 
-		args.DownloadedFile, err = resizeVideo(args, width, height)
-		if err != nil {
-			return nil, err
+	duration, err := getVideoDuration(ctx, args.DownloadedFile)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
 		}
+		slog.Warn("duration probe failed, disabling smooth loop", slog.String("file", args.DownloadedFile), slog.String("err", err.Error()))
+		args.Loop = ""
+		return nil
 	}
+	if _, end := Segment(args); duration < end {
+		slog.Info("video is too short for a smooth loop", slog.String("file", args.DownloadedFile), slog.Float64("duration", duration), slog.Float64("need", end))
+		args.Loop = ""
+	}
+	return nil
+}
 
-	originalHeight := height // Сохраняем исходную высоту
-	//height = RoundUpTo100(height)
-	lastRowHeight := originalHeight % 100 // Высота последнего ряда до округления
-
-	tileWidth := 100
-	tileHeight := 100
-	tilesX := width / tileWidth
-	tilesY := height / tileHeight
-	if lastRowHeight > 0 {
-		tilesY++
+// ProcessVideo нарезает видео на тайлы. Все запущенные процессы ffmpeg
+// завершаются при отмене ctx или по таймауту этапа.
+func ProcessVideo(ctx context.Context, args *types.EmojiCommand) ([]string, error) {
+	width, height, err := getVideoDimensions(ctx, args.DownloadedFile)
+	if err != nil {
+		return nil, err
 	}
 
-	baseFFmpegArgs := []string{
-		"-y",
-		"-i", args.DownloadedFile,
-		"-c:v", "libvpx-vp9",
-		"-profile:v", "0",
-		"-pix_fmt", "yuva420p",
-		"-crf", "24",
-		"-b:v", fmt.Sprintf("%d", args.QualityValue),
-		"-b:a", "256k",
-		"-t", "3.0",
-		"-r", "10",
-		"-auto-alt-ref", "1",
-		"-metadata:s:v:0", "alpha_mode=1",
-		"-an",
+	if err := SelectSegment(ctx, args); err != nil {
+		return nil, err
 	}
 
-	jobs := make(chan tile, tilesX*tilesY)
-	results := make(chan processResult, tilesX*tilesY)
-
-	numWorkers := 4
-	var wg sync.WaitGroup
-	for w := 0; w < numWorkers; w++ {
-		wg.Add(1)
-		go worker(jobs, results, &wg)
-	}
-
-	go func() {
-		position := 0
-		for j := 0; j < tilesY; j++ {
-			for i := 0; i < tilesX; i++ {
-				outputFile := filepath.Join(args.WorkingDir, fmt.Sprintf("emoji_%d_%d.webm", j, i))
-
-				var vfArgs []string
-
-				//fmt.Println(tilesY, tileWidth, tileHeight, lastRowHeight, height, width)
-
-				if j == tilesY-1 && lastRowHeight > 0 {
-					// 			 0xF5DEB3
-					padColor := "#04F404@0.1" // По умолчанию прозрачный
-					if args.BackgroundColor != "" {
-						padColor = args.BackgroundColor
-					} else if args.BackgroundBlend != "" {
-						args.BackgroundColor = padColor
-					}
-					vfArgs = []string{
-						fmt.Sprintf("crop=%d:%d:%d:%d", tileWidth, lastRowHeight, i*tileWidth, j*tileHeight),
-						fmt.Sprintf("scale=100:%d", lastRowHeight),
-						fmt.Sprintf("pad=100:100:%d:0:color=%s", 100-lastRowHeight, padColor),
-					}
-				} else {
-					vfArgs = []string{
-						fmt.Sprintf("crop=%d:%d:%d:%d", tileWidth, tileHeight, i*tileWidth, j*tileHeight),
-					}
-				}
-
-				if args.BackgroundColor != "" {
-					vfArgs = append(vfArgs, fmt.Sprintf("colorkey=%s:similarity=%s:blend=%s", args.BackgroundColor, args.BackgroundSim, args.BackgroundBlend))
-				}
-				vfArgs = append(vfArgs, "setsar=1:1")
-				ffmpegArgs := make([]string, len(baseFFmpegArgs))
-				copy(ffmpegArgs, baseFFmpegArgs)
-				ffmpegArgs = append(ffmpegArgs, "-vf", strings.Join(vfArgs, ","), outputFile)
-
-				jobs <- tile{
-					OutputFile: outputFile,
-					FFmpegArgs: ffmpegArgs,
-					Position:   position,
-				}
-				position++
-			}
-		}
-		close(jobs)
-	}()
-
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	resultSlice := make([]string, tilesX*tilesY)
-	var errors []error
-
-	for result := range results {
-		if result.err != nil {
-			errors = append(errors, result.err)
-			log.Printf("Error during processing: %v", result.err)
-			continue
-		}
-		resultSlice[result.position] = result.filename
+	if err := checkSmoothLoop(ctx, args); err != nil {
+		return nil, err
 	}
 
-	if len(errors) > 0 {
-		return nil, fmt.Errorf("encountered %d errors during processing", len(errors))
+	// Дальше команда описывает итоговую сетку: ее перечитывают перекодирование тайлов и /redo
+	f := planFrame(width, height, args)
+	if gridTooLarge(f.Cols, f.Rows) {
+		return nil, types.ErrGridTooLarge
 	}
+	args.Width, args.Height = f.Cols, f.Rows
 
-	// Убираем пустые элементы из результата
-	var finalResults []string
-	for _, res := range resultSlice {
-		if res != "" {
-			finalResults = append(finalResults, res)
-		}
+	args.DownloadedFile, err = resizeVideo(ctx, args, f.Filter)
+	if err != nil {
+		return nil, err
 	}
 
-	return finalResults, nil
-}
+	grid := newTileGrid(args, f.Width, f.Height)
+	if grid.Count() == 0 {
+		return nil, fmt.Errorf("видео слишком маленькое для нарезки (%dx%d)", width, height)
+	}
 
-func worker(jobs <-chan tile, results chan<- processResult, wg *sync.WaitGroup) {
-	defer wg.Done()
+	// Входное видео декодируется один раз, все тайлы пишутся за один проход
+	ffmpegArgs, outputs := buildTilerArgs(args, grid)
+	if err := runFFmpeg(ctx, args, tilesCost(grid.Count()), StageTiles, TilesTimeout, ffmpegArgs...); err != nil {
+		log.Printf("Error during processing: %v", err)
+		return nil, fmt.Errorf("ошибка при нарезке видео на %d тайлов: %w", grid.Count(), err)
+	}
 
-	for tile := range jobs {
-		cmd := exec.Command("ffmpeg", tile.FFmpegArgs...)
-		err := cmd.Run()
-		results <- processResult{
-			filename: tile.OutputFile,
-			position: tile.Position,
-			err:      err,
+	for _, output := range outputs {
+		if _, err := os.Stat(output); err != nil {
+			return nil, fmt.Errorf("тайл %s не создан: %w", filepath.Base(output), err)
 		}
 	}
+
+	return outputs, nil
 }
 
 func RemoveDirectory(directory string) error {
 	return os.RemoveAll(directory)
 }
 
-func resizeVideo(args *types.EmojiCommand, toWidth, toHeight int) (string, error) {
+// resizeVideo готовит видео к нарезке: вырезает отрезок по параметрам времени
+// команды и применяет фильтр кадра filter, см. planFrame
+func resizeVideo(ctx context.Context, args *types.EmojiCommand, filter string) (string, error) {
 	outputFile := filepath.Join(args.WorkingDir, "resized.webm")
 
-	cmd := exec.Command("ffmpeg",
-		"-i", args.DownloadedFile,
+	ffmpegArgs := append(timelineInputArgs(args), "-i", args.DownloadedFile)
+	ffmpegArgs = append(ffmpegArgs,
 		"-c:v", "libvpx-vp9",
-		"-vf", fmt.Sprintf("scale=%d:%d", toWidth, toHeight),
+		"-vf", timelineFilter(args, filter),
+		"-an",
 		"-y",
 		outputFile)
 
-	if err := cmd.Run(); err != nil {
+	err := runFFmpeg(ctx, args, 2, StageResize, ResizeTimeout, ffmpegArgs...)
+	if err != nil {
 		return "", fmt.Errorf("ошибка при изменении размера файла: %w", err)
 	}
 
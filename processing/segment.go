@@ -0,0 +1,134 @@
+package processing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"emoji-generator/types"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+const (
+	// sceneFPS с какой частотой кадров анализируется видео
+	sceneFPS = 10
+
+	// maxSceneAnalysis сколько секунд видео анализируется при auto=true
+	maxSceneAnalysis = 300
+
+	// sceneCut изменение кадра, начиная с которого это монтажная склейка, а не движение.
+	// Отрезок со склейкой плохо зацикливается.
+	sceneCut = 0.4
+)
+
+// sceneSample оценка изменения кадра относительно предыдущего, от 0 до 1
+type sceneSample struct {
+	Time  float64
+	Score float64
+}
+
+// SelectSegment при auto=true выбирает, с какой секунды брать видео: самый
+// подвижный отрезок без монтажных склеек. Если анализ не удался, видео берется
+// с начала. Start, заданный вручную, ParseArgs не дает перезаписать.
+func SelectSegment(ctx context.Context, args *types.EmojiCommand) error {
+	if !args.Auto {
+		return nil
+	}
+
+	samples, err := sceneSamples(ctx, args)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		slog.Warn("scene analysis failed, using the beginning of the video", slog.String("file", args.DownloadedFile), slog.String("err", err.Error()))
+		return nil
+	}
+
+	args.Start = pickSegment(samples, sourceSpan(args))
+	slog.Debug("segment selected", slog.String("file", args.DownloadedFile), slog.Float64("start", args.Start), slog.Int("samples", len(samples)))
+	return nil
+}
+
+// sceneSamples оценивает изменение каждого кадра фильтром scene
+func sceneSamples(ctx context.Context, args *types.EmojiCommand) ([]sceneSample, error) {
+	output, err := runScheduledFFmpeg(ctx, userRequest(args, 1), StageScenes, ScenesTimeout,
+		"-t", strconv.Itoa(maxSceneAnalysis),
+		"-i", args.DownloadedFile,
+		"-vf", fmt.Sprintf("fps=%d,scale=160:-2,select=gte(scene\\,0),metadata=mode=print:key=lavfi.scene_score:file=-", sceneFPS),
+		"-an",
+		"-f", "null",
+		"-",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return parseSceneScores(output)
+}
+
+// parseSceneScores разбирает вывод metadata=print: строка кадра с pts_time,
+// за ней значение lavfi.scene_score
+func parseSceneScores(output []byte) ([]sceneSample, error) {
+	var samples []sceneSample
+	var current float64
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "frame:") {
+			for _, field := range strings.Fields(line) {
+				if value, ok := strings.CutPrefix(field, "pts_time:"); ok {
+					current, _ = strconv.ParseFloat(value, 64)
+				}
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "lavfi.scene_score="); ok {
+			score, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse scene score %q: %w", value, err)
+			}
+			samples = append(samples, sceneSample{Time: current, Score: score})
+		}
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no scene scores")
+	}
+	return samples, scanner.Err()
+}
+
+// pickSegment возвращает начало отрезка длиной span с наибольшим суммарным
+// движением. Отрезки с монтажной склейкой выбираются, только если других нет.
+func pickSegment(samples []sceneSample, span float64) float64 {
+	end := samples[len(samples)-1].Time
+	if end <= span {
+		return 0
+	}
+
+	best, bestActivity, bestHasCut := 0.0, -1.0, true
+	for i, first := range samples {
+		if first.Time+span > end {
+			break
+		}
+
+		activity, hasCut := 0.0, false
+		// Оценка кадра - изменение относительно предыдущего, первый кадр отрезка не считается
+		for _, s := range samples[i+1:] {
+			if s.Time > first.Time+span {
+				break
+			}
+			if s.Score >= sceneCut {
+				hasCut = true
+				continue
+			}
+			activity += s.Score
+		}
+
+		if (bestHasCut && !hasCut) || (hasCut == bestHasCut && activity > bestActivity) {
+			best, bestActivity, bestHasCut = first.Time, activity, hasCut
+		}
+	}
+	return best
+}
@@ -0,0 +1,70 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func samplesAt(fps int, scores ...float64) []sceneSample {
+	samples := make([]sceneSample, len(scores))
+	for i, score := range scores {
+		samples[i] = sceneSample{Time: float64(i) / float64(fps), Score: score}
+	}
+	return samples
+}
+
+func TestSegment_PickMostActive(t *testing.T) {
+	// Тихое начало, движение с 3 до 5 секунды
+	scores := make([]float64, 100)
+	for i := 30; i < 50; i++ {
+		scores[i] = 0.1
+	}
+	assert.InDelta(t, 2.9, pickSegment(samplesAt(10, scores...), 2), 0.001)
+}
+
+func TestSegment_AvoidCuts(t *testing.T) {
+	// Самый подвижный отрезок содержит склейку, выбирается отрезок без нее
+	scores := make([]float64, 100)
+	for i := 10; i < 30; i++ {
+		scores[i] = 0.2
+	}
+	scores[20] = 0.9
+	for i := 60; i < 80; i++ {
+		scores[i] = 0.05
+	}
+	start := pickSegment(samplesAt(10, scores...), 2)
+	assert.GreaterOrEqual(t, start, 2.0)
+	assert.LessOrEqual(t, start, 6.0)
+}
+
+func TestSegment_ShortVideo(t *testing.T) {
+	assert.Equal(t, 0.0, pickSegment(samplesAt(10, 0.1, 0.2, 0.3), 3))
+}
+
+func TestSegment_ParseSceneScores(t *testing.T) {
+	output := []byte(`frame:0    pts:0       pts_time:0
+lavfi.scene_score=0.000000
+frame:1    pts:1       pts_time:0.1
+lavfi.scene_score=0.250000
+`)
+	samples, err := parseSceneScores(output)
+	require.NoError(t, err)
+	assert.Equal(t, []sceneSample{{Time: 0, Score: 0}, {Time: 0.1, Score: 0.25}}, samples)
+}
+
+func TestSegment_ManualStartWins(t *testing.T) {
+	args, err := ParseArgs("auto=true start=4")
+	require.NoError(t, err)
+	assert.False(t, args.Auto)
+	assert.Equal(t, 4.0, args.Start)
+
+	args, err = ParseArgs("auto=true")
+	require.NoError(t, err)
+	assert.True(t, args.Auto)
+	start, end := Segment(args)
+	assert.Equal(t, 0.0, start)
+	assert.Equal(t, types.MaxEmojiDuration, end)
+}
@@ -0,0 +1,59 @@
+package processing
+
+import (
+	"context"
+	"emoji-generator/scheduler"
+	"emoji-generator/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spacerDuration длительность прозрачного тайла, с
+const spacerDuration = 1.0
+
+var spacerTile struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// SpacerTile возвращает полностью прозрачный тайл, которым заполняются отступы
+// узких композиций. Тайл генерируется ffmpeg один раз и кэшируется: он одинаков
+// для всех композиций.
+func SpacerTile(ctx context.Context) ([]byte, error) {
+	spacerTile.mu.Lock()
+	defer spacerTile.mu.Unlock()
+
+	if spacerTile.data != nil {
+		return spacerTile.data, nil
+	}
+
+	dir, err := os.MkdirTemp("", "spacer")
+	if err != nil {
+		return nil, fmt.Errorf("create spacer dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "spacer.webm")
+	profile := profileFor(types.QualitySmall)
+	ffmpegArgs := []string{
+		"-y",
+		"-f", "lavfi",
+		"-i", fmt.Sprintf("color=c=black@0.0:s=%dx%d:r=10:d=%.1f,format=rgba", types.EmojiSize, types.EmojiSize, spacerDuration),
+	}
+	ffmpegArgs = append(ffmpegArgs, tileEncoderArgs(profile, budgetBitrate(types.MaxEmojiFileSize/8, spacerDuration))...)
+	ffmpegArgs = append(ffmpegArgs, output)
+
+	if _, err := runScheduledFFmpeg(ctx, scheduler.Request{Cost: 1}, StageEncode, EncodeTimeout, ffmpegArgs...); err != nil {
+		return nil, fmt.Errorf("ошибка при создании прозрачного тайла: %w", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("read spacer tile: %w", err)
+	}
+
+	spacerTile.data = data
+	return data, nil
+}
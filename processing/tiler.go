@@ -0,0 +1,124 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+)
+
+const tileSize = 100
+
+// tileGrid описывает сетку, на которую режется подготовленное видео
+type tileGrid struct {
+	Cols int
+	Rows int
+	// Content где на сетке лежит видео. Вне его тайлы прозрачные: так видео,
+	// вписанное с fit=contain, получает поля с нужной стороны.
+	Content image.Rectangle
+}
+
+// newTileGrid кладет подготовленное видео width x height на сетку команды:
+// по горизонтали по центру, по вертикали по ее привязке
+func newTileGrid(args *types.EmojiCommand, width, height int) tileGrid {
+	grid := tileGrid{Cols: args.Width, Rows: args.Height}
+	if grid.Cols <= 0 {
+		grid.Cols = width / tileSize
+	}
+	if grid.Rows <= 0 {
+		grid.Rows = (height + tileSize - 1) / tileSize
+	}
+
+	x := anchorOffset(types.AnchorCenter, grid.Cols*tileSize-width)
+	y := anchorOffset(args.FrameAnchor(), grid.Rows*tileSize-height)
+	grid.Content = image.Rect(x, y, x+width, y+height)
+	return grid
+}
+
+// Count возвращает общее количество тайлов в сетке
+func (g tileGrid) Count() int {
+	return g.Cols * g.Rows
+}
+
+// tileRect возвращает область тайла на сетке
+func (g tileGrid) tileRect(row, col int) image.Rectangle {
+	return image.Rect(col*tileSize, row*tileSize, (col+1)*tileSize, (row+1)*tileSize)
+}
+
+func tileOutputFile(workingDir string, row, col int) string {
+	return filepath.Join(workingDir, fmt.Sprintf("emoji_%d_%d.webm", row, col))
+}
+
+// tileFilter возвращает цепочку фильтров, вырезающую один тайл из подготовленного видео.
+// Часть тайла вне видео остается прозрачной: фон вырезается до дополнения тайла,
+// иначе colorkey перезаписал бы прозрачность полей.
+func tileFilter(args *types.EmojiCommand, grid tileGrid, row, col int) string {
+	tile := grid.tileRect(row, col)
+	part := tile.Intersect(grid.Content)
+	if part.Empty() {
+		return fmt.Sprintf("scale=%d:%d,format=rgba,colorchannelmixer=aa=0,setsar=1:1", tileSize, tileSize)
+	}
+
+	vf := []string{
+		fmt.Sprintf("crop=%d:%d:%d:%d", part.Dx(), part.Dy(), part.Min.X-grid.Content.Min.X, part.Min.Y-grid.Content.Min.Y),
+	}
+	if args.BackgroundColor != "" {
+		vf = append(vf, fmt.Sprintf("colorkey=%s:similarity=%s:blend=%s", args.BackgroundColor, args.BackgroundSim, args.BackgroundBlend))
+	}
+	if part != tile {
+		vf = append(vf,
+			"format=yuva420p",
+			fmt.Sprintf("pad=%d:%d:%d:%d:color=black@0", tileSize, tileSize, part.Min.X-tile.Min.X, part.Min.Y-tile.Min.Y),
+		)
+	}
+	vf = append(vf, "setsar=1:1")
+
+	return strings.Join(vf, ",")
+}
+
+// buildTilerArgs собирает аргументы одного запуска ffmpeg, который декодирует
+// входное видео один раз и через split/crop граф пишет все тайлы сетки.
+// Возвращает аргументы и упорядоченный по позиции список выходных файлов.
+func buildTilerArgs(args *types.EmojiCommand, grid tileGrid) ([]string, []string) {
+	count := grid.Count()
+	outputs := make([]string, 0, count)
+
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "[0:v]fps=%d", clipFPS(args))
+	fmt.Fprintf(&graph, ",split=%d", count)
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&graph, "[s%d]", i)
+	}
+
+	position := 0
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			fmt.Fprintf(&graph, ";[s%d]%s[t%d]", position, tileFilter(args, grid, row, col), position)
+			position++
+		}
+	}
+
+	ffmpegArgs := []string{
+		"-y",
+		"-t", fmt.Sprintf("%.3f", clipDuration(args)),
+		"-i", args.DownloadedFile,
+		"-filter_complex", graph.String(),
+	}
+
+	profile := profileFor(args.Quality)
+	encoderArgs := tileEncoderArgs(profile, budgetBitrate(profile.Budget, clipDuration(args)))
+	position = 0
+	for row := 0; row < grid.Rows; row++ {
+		for col := 0; col < grid.Cols; col++ {
+			outputFile := tileOutputFile(args.WorkingDir, row, col)
+			ffmpegArgs = append(ffmpegArgs, "-map", fmt.Sprintf("[t%d]", position))
+			ffmpegArgs = append(ffmpegArgs, encoderArgs...)
+			ffmpegArgs = append(ffmpegArgs, outputFile)
+			outputs = append(outputs, outputFile)
+			position++
+		}
+	}
+
+	return ffmpegArgs, outputs
+}
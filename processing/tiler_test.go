@@ -0,0 +1,123 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiler_BuildTilerArgs(t *testing.T) {
+	args := &types.EmojiCommand{
+		DownloadedFile:  "/tmp/test/resized.webm",
+		WorkingDir:      "/tmp/test",
+		BackgroundSim:   "0.1",
+		BackgroundBlend: "0.1",
+		Width:           3,
+		Height:          3,
+		Fit:             types.FitContain,
+	}
+	grid := newTileGrid(args, 300, 250)
+	require.Equal(t, 3, grid.Cols)
+	require.Equal(t, 3, grid.Rows)
+	require.Equal(t, image.Rect(0, 0, 300, 250), grid.Content)
+
+	ffmpegArgs, outputs := buildTilerArgs(args, grid)
+
+	require.Len(t, outputs, 9)
+	assert.Equal(t, "/tmp/test/emoji_0_0.webm", outputs[0])
+	assert.Equal(t, "/tmp/test/emoji_1_2.webm", outputs[5])
+	assert.Equal(t, "/tmp/test/emoji_2_2.webm", outputs[8])
+
+	// Один вход — одно декодирование
+	inputs := 0
+	for _, a := range ffmpegArgs {
+		if a == "-i" {
+			inputs++
+		}
+	}
+	assert.Equal(t, 1, inputs)
+
+	var graph string
+	for i, a := range ffmpegArgs {
+		if a == "-filter_complex" {
+			graph = ffmpegArgs[i+1]
+		}
+	}
+	assert.Contains(t, graph, "split=9[s0]")
+	assert.Contains(t, graph, "[s4]crop=100:100:100:100,setsar=1:1[t4]")
+	// Видео прижато к верху, поля неполного ряда прозрачные и снизу
+	assert.Contains(t, graph, "[s7]crop=100:50:100:200,format=yuva420p,pad=100:100:0:0:color=black@0,setsar=1:1[t7]")
+	// Без фона цветовой ключ не нужен
+	assert.Equal(t, 0, strings.Count(graph, "colorkey"))
+}
+
+func TestTiler_PlanFrame(t *testing.T) {
+	// Ширина по умолчанию, ряды по пропорциям: 1000x750 -> 800x600
+	f := planFrame(1000, 750, &types.EmojiCommand{Width: 8, Fit: types.FitContain})
+	assert.Equal(t, frame{Cols: 8, Rows: 6, Width: 800, Height: 600, Filter: "scale=800:600"}, f)
+
+	// Неполный ряд: видео прижато к верху, поля снизу. Так же резала команда
+	// до появления fit=, поэтому contain безопасен как значение по умолчанию
+	f = planFrame(1000, 700, &types.EmojiCommand{Width: 8, Fit: types.FitContain})
+	assert.Equal(t, 6, f.Rows)
+	assert.Equal(t, 560, f.Height)
+	grid := newTileGrid(&types.EmojiCommand{Width: f.Cols, Height: f.Rows, Fit: types.FitContain}, f.Width, f.Height)
+	assert.Equal(t, image.Rect(0, 0, 800, 560), grid.Content)
+
+	defaults := &types.EmojiCommand{}
+	defaults.SetDefault()
+	assert.Equal(t, f, planFrame(1000, 700, defaults))
+
+	// contain с заданной высотой: поля по бокам, по центру
+	args := &types.EmojiCommand{Width: 8, Height: 2, Fit: types.FitContain, Anchor: types.AnchorBottom}
+	f = planFrame(1000, 500, args)
+	assert.Equal(t, "scale=400:200", f.Filter)
+	grid = newTileGrid(&types.EmojiCommand{Width: f.Cols, Height: f.Rows, Fit: args.Fit, Anchor: args.Anchor}, f.Width, f.Height)
+	assert.Equal(t, image.Rect(200, 0, 600, 200), grid.Content)
+
+	// cover обрезает лишнее по привязке
+	f = planFrame(1000, 1000, &types.EmojiCommand{Width: 4, Height: 2, Fit: types.FitCover, Anchor: types.AnchorBottom})
+	assert.Equal(t, "scale=400:400,crop=400:200:0:200", f.Filter)
+	f = planFrame(1000, 1000, &types.EmojiCommand{Width: 4, Height: 2, Fit: types.FitCover})
+	assert.Equal(t, "scale=400:400,crop=400:200:0:100", f.Filter)
+
+	// stretch растягивает на всю сетку
+	f = planFrame(1000, 1000, &types.EmojiCommand{Width: 4, Height: 2, Fit: types.FitStretch})
+	assert.Equal(t, "scale=400:200", f.Filter)
+
+	// crop вырезает кусок без масштабирования
+	f = planFrame(1001, 501, &types.EmojiCommand{Width: 4, Height: 2, Fit: types.FitCrop, Anchor: types.AnchorTop})
+	assert.Equal(t, "crop=400:200:300:0", f.Filter)
+}
+
+func TestTiler_TransparentTile(t *testing.T) {
+	args := &types.EmojiCommand{Width: 3, Height: 1, Fit: types.FitContain, BackgroundColor: "0x000000", BackgroundSim: "0.1", BackgroundBlend: "0.1"}
+	grid := newTileGrid(args, 100, 100)
+	require.Equal(t, image.Rect(100, 0, 200, 100), grid.Content)
+
+	// Тайл вне видео целиком прозрачный, внутри видео - без полей
+	assert.Contains(t, tileFilter(args, grid, 0, 0), "colorchannelmixer=aa=0")
+	assert.Equal(t, "crop=100:100:0:0,colorkey=0x000000:similarity=0.1:blend=0.1,setsar=1:1", tileFilter(args, grid, 0, 1))
+}
+
+func TestTiler_QualityProfiles(t *testing.T) {
+	for _, quality := range []string{types.QualityHigh, types.QualityBalanced, types.QualitySmall} {
+		profile := profileFor(quality)
+		assert.LessOrEqual(t, profile.Budget, int64(types.MaxEmojiFileSize))
+		// Видео по битрейту бюджета укладывается в бюджет
+		assert.LessOrEqual(t, int64(budgetBitrate(profile.Budget, tileDuration))*3/8, profile.Budget)
+	}
+	assert.Less(t, profileFor(types.QualitySmall).Budget, profileFor(types.QualityHigh).Budget)
+	assert.Equal(t, profileFor(types.DefaultQuality), profileFor("unknown"))
+
+	emojiArgs, err := ParseArgs("q=[small]")
+	require.NoError(t, err)
+	assert.Equal(t, types.QualitySmall, emojiArgs.Quality)
+
+	_, err = ParseArgs("q=[ultra]")
+	assert.ErrorIs(t, err, types.ErrInvalidQuality)
+}
@@ -0,0 +1,98 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"fmt"
+	"strings"
+)
+
+// clipDuration длительность эмодзи в секундах
+func clipDuration(args *types.EmojiCommand) float64 {
+	if args.Duration > 0 {
+		return min(args.Duration, types.MaxEmojiDuration)
+	}
+	return tileDuration
+}
+
+// clipFPS частота кадров эмодзи
+func clipFPS(args *types.EmojiCommand) int {
+	if args.FPS > 0 {
+		return min(args.FPS, types.MaxEmojiFPS)
+	}
+	return types.DefaultEmojiFPS
+}
+
+func clipSpeed(args *types.EmojiCommand) float64 {
+	if args.Speed > 0 {
+		return args.Speed
+	}
+	return 1
+}
+
+// smoothFade длительность перехода из конца в начало при loop=smooth, в секундах эмодзи
+func smoothFade(args *types.EmojiCommand) float64 {
+	return min(maxSmoothFade, clipDuration(args)/4)
+}
+
+// maxSmoothFade переход дольше полсекунды заметен как наплыв, а не как петля
+const maxSmoothFade = 0.5
+
+// sourceSpan сколько секунд исходного видео занимает эмодзи: с ускорением
+// берется больше, бумеранг проигрывает отрезок дважды, а плавной петле нужен
+// запас на переход
+func sourceSpan(args *types.EmojiCommand) float64 {
+	duration := clipDuration(args)
+	switch args.Loop {
+	case types.LoopBoomerang:
+		duration /= 2
+	case types.LoopSmooth:
+		duration += smoothFade(args)
+	}
+	return duration * clipSpeed(args)
+}
+
+// Segment возвращает, с какой по какую секунду исходного видео взят отрезок
+func Segment(args *types.EmojiCommand) (float64, float64) {
+	return args.Start, args.Start + sourceSpan(args)
+}
+
+// timelineInputArgs параметры входа ffmpeg: читается только нужный отрезок видео
+func timelineInputArgs(args *types.EmojiCommand) []string {
+	var input []string
+	if args.Start > 0 {
+		input = append(input, "-ss", fmt.Sprintf("%.3f", args.Start))
+	}
+	return append(input, "-t", fmt.Sprintf("%.3f", sourceSpan(args)))
+}
+
+// timelineFilter переводит отрезок из timelineInputArgs во время эмодзи: скорость,
+// частота кадров, затем frameFilter и обратное проигрывание. Кадры пересчитываются
+// до нарезки, поэтому все тайлы получают одни и те же кадры.
+func timelineFilter(args *types.EmojiCommand, frameFilter string) string {
+	vf := []string{fmt.Sprintf("setpts=(PTS-STARTPTS)/%g", clipSpeed(args))}
+	vf = append(vf, fmt.Sprintf("fps=%d", clipFPS(args)))
+	vf = append(vf, frameFilter)
+	if args.Reverse {
+		vf = append(vf, "reverse")
+	}
+
+	filter := strings.Join(vf, ",")
+	switch args.Loop {
+	case types.LoopBoomerang:
+		filter += ",split[forward][back];[back]reverse[backward];[forward][backward]concat=n=2:v=1:a=0"
+	case types.LoopSmooth:
+		filter += smoothLoopFilter(clipDuration(args), smoothFade(args))
+	}
+	return filter + fmt.Sprintf(",trim=duration=%.3f", clipDuration(args))
+}
+
+// smoothLoopFilter зацикливает отрезок длиной duration+fade: эмодзи начинается
+// с секунды fade, а за последние fade секунд плавно переходит в начало отрезка.
+// Последний кадр перехода - первый кадр эмодзи, поэтому стыка при повторе не видно.
+func smoothLoopFilter(duration, fade float64) string {
+	return fmt.Sprintf(",split[body][head];"+
+		"[body]trim=start=%.3f,setpts=PTS-STARTPTS[loopbody];"+
+		"[head]trim=duration=%.3f,setpts=PTS-STARTPTS[loophead];"+
+		"[loopbody][loophead]xfade=transition=fade:duration=%.3f:offset=%.3f",
+		fade, fade, fade, duration-fade)
+}
@@ -0,0 +1,58 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeline_Defaults(t *testing.T) {
+	args := &types.EmojiCommand{}
+	assert.Equal(t, []string{"-t", "3.000"}, timelineInputArgs(args))
+	assert.Equal(t, "setpts=(PTS-STARTPTS)/1,fps=10,scale=800:600,trim=duration=3.000", timelineFilter(args, "scale=800:600"))
+}
+
+func TestTimeline_Controls(t *testing.T) {
+	args, err := ParseArgs("start=1,5 dur=2 fps=30 speed=2 reverse=true loop=boomerang")
+	require.NoError(t, err)
+
+	// Бумеранг проигрывает отрезок дважды, ускорение берет вдвое больше видео
+	assert.Equal(t, []string{"-ss", "1.500", "-t", "2.000"}, timelineInputArgs(args))
+	assert.Equal(t,
+		"setpts=(PTS-STARTPTS)/2,fps=30,scale=800:600,reverse,split[forward][back];[back]reverse[backward];[forward][backward]concat=n=2:v=1:a=0,trim=duration=2.000",
+		timelineFilter(args, "scale=800:600"))
+}
+
+func TestTimeline_SmoothLoop(t *testing.T) {
+	args, err := ParseArgs("dur=2 loop=smooth")
+	require.NoError(t, err)
+
+	// Переход занимает четверть эмодзи, на него читается запас видео
+	assert.Equal(t, []string{"-t", "2.500"}, timelineInputArgs(args))
+	assert.Equal(t,
+		"setpts=(PTS-STARTPTS)/1,fps=10,scale=800:600,split[body][head];"+
+			"[body]trim=start=0.500,setpts=PTS-STARTPTS[loopbody];"+
+			"[head]trim=duration=0.500,setpts=PTS-STARTPTS[loophead];"+
+			"[loopbody][loophead]xfade=transition=fade:duration=0.500:offset=1.500,trim=duration=2.000",
+		timelineFilter(args, "scale=800:600"))
+
+	args.Duration = 1
+	assert.InDelta(t, 0.25, smoothFade(args), 1e-9)
+}
+
+func TestTimeline_Validation(t *testing.T) {
+	for arg, want := range map[string]error{
+		"start=-1":      types.ErrInvalidStart,
+		"dur=4":         types.ErrInvalidDuration,
+		"dur=0":         types.ErrInvalidDuration,
+		"fps=60":        types.ErrInvalidFPS,
+		"speed=10":      types.ErrInvalidSpeed,
+		"reverse=maybe": types.ErrInvalidReverse,
+		"loop=forever":  types.ErrInvalidLoop,
+	} {
+		_, err := ParseArgs(arg)
+		assert.ErrorIs(t, err, want, arg)
+	}
+}
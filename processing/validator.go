@@ -0,0 +1,203 @@
+package processing
+
+import (
+	"context"
+	"emoji-generator/types"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TileReport результат проверки одного тайла на соответствие требованиям
+// Telegram к видео кастомных эмодзи
+type TileReport struct {
+	File     string   `json:"file"`
+	Position int      `json:"position"`
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Codec    string   `json:"codec"`
+	Format   string   `json:"format"`
+	HasAudio bool     `json:"has_audio"`
+	Duration float64  `json:"duration"`
+	FPS      float64  `json:"fps"`
+	Size     int64    `json:"size"`
+	Problems []string `json:"problems"`
+}
+
+func (r TileReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// ValidationReport результат проверки всех тайлов композиции
+type ValidationReport struct {
+	Tiles []TileReport `json:"tiles"`
+}
+
+// Failed возвращает тайлы, не прошедшие проверку
+func (r *ValidationReport) Failed() []TileReport {
+	var failed []TileReport
+	for _, tile := range r.Tiles {
+		if !tile.OK() {
+			failed = append(failed, tile)
+		}
+	}
+	return failed
+}
+
+func (r *ValidationReport) OK() bool {
+	return len(r.Failed()) == 0
+}
+
+// Err возвращает ErrTilesNotValid с перечислением проблем или nil, если все тайлы прошли проверку
+func (r *ValidationReport) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	const maxListed = 3
+	var lines []string
+	for i, tile := range failed {
+		if i == maxListed {
+			lines = append(lines, fmt.Sprintf("и еще %d", len(failed)-maxListed))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", filepath.Base(tile.File), strings.Join(tile.Problems, ", ")))
+	}
+
+	return fmt.Errorf("%w (%d из %d):\n%s", types.ErrTilesNotValid, len(failed), len(r.Tiles), strings.Join(lines, "\n"))
+}
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		RFrameRate   string `json:"r_frame_rate"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+	} `json:"format"`
+}
+
+func probeTile(ctx context.Context, file string) (*probeOutput, error) {
+	output, err := runFFprobe(ctx,
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,width,height,avg_frame_rate,r_frame_rate:format=format_name,duration",
+		"-of", "json",
+		file)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe %s: %w", filepath.Base(file), err)
+	}
+
+	var probe probeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("parse ffprobe output for %s: %w", filepath.Base(file), err)
+	}
+
+	return &probe, nil
+}
+
+// parseFrameRate разбирает частоту кадров ffprobe в формате "30/1"
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// newTileReport сопоставляет результат ffprobe с требованиями Telegram
+func newTileReport(file string, position int, size int64, probe *probeOutput) TileReport {
+	report := TileReport{
+		File:     file,
+		Position: position,
+		Size:     size,
+		Format:   probe.Format.FormatName,
+	}
+	report.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+
+	videoStreams := 0
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			videoStreams++
+			report.Codec = stream.CodecName
+			report.Width = stream.Width
+			report.Height = stream.Height
+			report.FPS = parseFrameRate(stream.AvgFrameRate)
+			if report.FPS == 0 {
+				report.FPS = parseFrameRate(stream.RFrameRate)
+			}
+		case "audio":
+			report.HasAudio = true
+		}
+	}
+
+	if videoStreams != 1 {
+		report.Problems = append(report.Problems, fmt.Sprintf("видеопотоков %d вместо 1", videoStreams))
+	}
+	if report.Width != types.EmojiSize || report.Height != types.EmojiSize {
+		report.Problems = append(report.Problems, fmt.Sprintf("размер %dx%d вместо %dx%d", report.Width, report.Height, types.EmojiSize, types.EmojiSize))
+	}
+	if report.Codec != "vp9" {
+		report.Problems = append(report.Problems, fmt.Sprintf("кодек %s вместо vp9", report.Codec))
+	}
+	if !strings.Contains(report.Format, "webm") {
+		report.Problems = append(report.Problems, fmt.Sprintf("контейнер %s вместо webm", report.Format))
+	}
+	if report.HasAudio {
+		report.Problems = append(report.Problems, "есть звуковая дорожка")
+	}
+	// Небольшой допуск на округление длительности контейнера
+	if report.Duration > types.MaxEmojiDuration+0.01 {
+		report.Problems = append(report.Problems, fmt.Sprintf("длительность %.2fс больше %.0fс", report.Duration, types.MaxEmojiDuration))
+	}
+	if report.FPS > types.MaxEmojiFPS {
+		report.Problems = append(report.Problems, fmt.Sprintf("%.0f fps больше %d", report.FPS, types.MaxEmojiFPS))
+	}
+	if report.Size > types.MaxEmojiFileSize {
+		report.Problems = append(report.Problems, fmt.Sprintf("размер файла %d KB больше %d KB", report.Size/1024, types.MaxEmojiFileSize/1024))
+	}
+
+	return report
+}
+
+// ValidateTiles проверяет каждый тайл через ffprobe на соответствие требованиям
+// Telegram к видео кастомных эмодзи. Ошибка возвращается только если тайл
+// не удалось прочитать, несоответствия требованиям собираются в отчет.
+func ValidateTiles(ctx context.Context, files []string) (*ValidationReport, error) {
+	report := &ValidationReport{
+		Tiles: make([]TileReport, 0, len(files)),
+	}
+
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("stat tile %s: %w", filepath.Base(file), err)
+		}
+
+		probe, err := probeTile(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Tiles = append(report.Tiles, newTileReport(file, i, info.Size(), probe))
+	}
+
+	return report, nil
+}
@@ -0,0 +1,40 @@
+package processing
+
+import (
+	"emoji-generator/types"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidator_NewTileReport(t *testing.T) {
+	var valid probeOutput
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"streams": [{"codec_type": "video", "codec_name": "vp9", "width": 100, "height": 100, "avg_frame_rate": "10/1"}],
+		"format": {"format_name": "matroska,webm", "duration": "3.000000"}
+	}`), &valid))
+
+	report := newTileReport("/tmp/test/emoji_0_0.webm", 0, 40*1024, &valid)
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, 10.0, report.FPS)
+
+	var invalid probeOutput
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"streams": [
+			{"codec_type": "video", "codec_name": "h264", "width": 100, "height": 50, "avg_frame_rate": "60000/1001"},
+			{"codec_type": "audio", "codec_name": "opus"}
+		],
+		"format": {"format_name": "mov,mp4", "duration": "4.5"}
+	}`), &invalid))
+
+	report = newTileReport("/tmp/test/emoji_0_1.webm", 1, 300*1024, &invalid)
+	assert.False(t, report.OK())
+	assert.Len(t, report.Problems, 7)
+
+	full := &ValidationReport{Tiles: []TileReport{newTileReport("a.webm", 0, 1024, &valid), report}}
+	assert.Len(t, full.Failed(), 1)
+	assert.True(t, errors.Is(full.Err(), types.ErrTilesNotValid))
+}
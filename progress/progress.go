@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -89,6 +90,11 @@ func (m *Manager) UpdateMessage(ctx context.Context, chatID int64, msgID int, st
 	}
 
 	progress := progressRaw.(*Message)
+	// Telegram отклоняет редактирование без изменений
+	if progress.Status == status {
+		return nil
+	}
+
 	params := &bot.EditMessageTextParams{
 		ChatID:    chatID,
 		MessageID: progress.MessageID,
@@ -103,3 +109,34 @@ func (m *Manager) UpdateMessage(ctx context.Context, chatID int64, msgID int, st
 	progress.Status = status
 	return nil
 }
+
+// Restore регистрирует ранее отправленное сообщение о прогрессе,
+// например после перезапуска, чтобы его можно было обновлять и удалять
+func (m *Manager) Restore(chatID int64, msgID int, status string) *Message {
+	progress := &Message{
+		ChatID:    chatID,
+		MessageID: msgID,
+		Status:    status,
+	}
+
+	key := strconv.FormatInt(chatID, 10) + ":" + strconv.Itoa(msgID)
+	actual, _ := m.progressMessages.LoadOrStore(key, progress)
+	return actual.(*Message)
+}
+
+// QueueStatus текст сообщения о месте в очереди и примерном времени ожидания
+func QueueStatus(position int, eta time.Duration) string {
+	text := fmt.Sprintf("⏳ Ожидаем очереди, перед вами: %d", position-1)
+	if position <= 1 {
+		text = "⏳ Вы следующий в очереди"
+	}
+
+	switch {
+	case eta <= 0:
+		return text
+	case eta < time.Minute:
+		return text + "\nПримерное время ожидания: меньше минуты"
+	default:
+		return text + fmt.Sprintf("\nПримерное время ожидания: ~%.0f мин", eta.Minutes())
+	}
+}
@@ -1,116 +1,265 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"time"
 )
 
+// ErrQueueCleared возвращается ожидающим, если очередь была очищена до их очереди
+var ErrQueueCleared = errors.New("sticker queue cleared")
+
+// DefaultLimit сколько паков по умолчанию обрабатывается одновременно
+const DefaultLimit = 4
+
+// historySize по скольким последним обработкам оценивается время ожидания
+const historySize = 20
+
+// Status положение запроса в очереди
+type Status struct {
+	Position int           // место в очереди, 0 - доступ выдан
+	ETA      time.Duration // оценка ожидания, 0 - пока неизвестна
+}
+
+// StickerQueue блокировка на уровне пака: запись в один пак строго
+// последовательна, разные паки обрабатываются параллельно, но не больше limit
+// одновременно. Ожидающие обслуживаются в порядке очереди.
 type StickerQueue struct {
-	mu           sync.Mutex
-	currentPack  string                     // текущий обрабатываемый пак
-	packQueue    []string                   // очередь паков
-	waitChannels map[string][]chan struct{} // каналы ожидания для каждого пака
+	mu      sync.Mutex
+	limit   int
+	active  map[string]*Ticket // паки, которые сейчас обрабатываются
+	waiters []*Ticket          // очередь в порядке постановки
+	changed chan struct{}      // закрывается при каждом движении очереди
+	history []time.Duration    // длительности последних обработок
+}
+
+// Ticket место в очереди на обработку пака
+type Ticket struct {
+	queue   *StickerQueue
+	pack    string
+	ready   chan struct{}
+	granted bool
+	since   time.Time
+	err     error
+	once    sync.Once
 }
 
-func New() *StickerQueue {
+func New(limit int) *StickerQueue {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
 	return &StickerQueue{
-		waitChannels: make(map[string][]chan struct{}),
+		limit:   limit,
+		active:  make(map[string]*Ticket),
+		changed: make(chan struct{}),
 	}
 }
 
-// Acquire пытается получить доступ к обработке пака
-// Возвращает true если можно сразу обрабатывать этот пак
-// Если false - нужно ждать сигнала по каналу
-func (sq *StickerQueue) Acquire(packLink string) (bool, chan struct{}) {
+// Join ставит запрос на обработку пака в очередь. Если пак свободен и лимит
+// не исчерпан, доступ выдается сразу.
+func (sq *StickerQueue) Join(packLink string) *Ticket {
 	sq.mu.Lock()
 	defer sq.mu.Unlock()
 
-	// Если очередь пустая и нет текущего пака - сразу начинаем обработку
-	if sq.currentPack == "" {
-		sq.currentPack = packLink
-		return true, nil
+	t := &Ticket{
+		queue: sq,
+		pack:  packLink,
+		ready: make(chan struct{}),
 	}
+	sq.waiters = append(sq.waiters, t)
+	sq.dispatch()
+	sq.notify()
+	return t
+}
 
-	// Если это текущий пак - разрешаем обработку
-	if sq.currentPack == packLink {
-		return true, nil
+// Acquire ждет доступа к обработке пака. После обработки нужно вызвать Release.
+func (sq *StickerQueue) Acquire(ctx context.Context, packLink string) (*Ticket, error) {
+	t := sq.Join(packLink)
+	if err := t.Wait(ctx); err != nil {
+		return nil, err
 	}
+	return t, nil
+}
+
+// Len возвращает число ожидающих и обрабатываемых паков
+func (sq *StickerQueue) Len() (waiting int, active int) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	return len(sq.waiters), len(sq.active)
+}
 
-	// Создаем канал ожидания для этого пака
-	ch := make(chan struct{})
-	sq.waitChannels[packLink] = append(sq.waitChannels[packLink], ch)
+// Clear снимает с очереди всех ожидающих, их Wait вернет ErrQueueCleared.
+// Паки, которые уже обрабатываются, освобождаются своими владельцами.
+func (sq *StickerQueue) Clear() {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
 
-	// Добавляем пак в очередь, если его там еще нет
-	if !sq.isInQueue(packLink) {
-		sq.packQueue = append(sq.packQueue, packLink)
+	for _, t := range sq.waiters {
+		t.err = ErrQueueCleared
+		close(t.ready)
 	}
+	sq.waiters = nil
+	sq.notify()
+}
 
-	return false, ch
+// Position возвращает место в очереди, начиная с 1, или 0, если доступ уже выдан
+func (t *Ticket) Position() int {
+	sq := t.queue
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	return sq.position(t)
 }
 
-// Release освобождает текущий пак и переходит к следующему в очереди
-func (sq *StickerQueue) Release(packLink string) {
+// Status возвращает место в очереди и оценку времени ожидания
+func (t *Ticket) Status() Status {
+	sq := t.queue
 	sq.mu.Lock()
 	defer sq.mu.Unlock()
+	return sq.status(t)
+}
 
-	// Если это не текущий пак - ничего не делаем
-	if sq.currentPack != packLink {
-		return
-	}
+// Wait ждет выдачи доступа. Если ctx отменен раньше, запрос снимается с очереди.
+func (t *Ticket) Wait(ctx context.Context) error {
+	return t.WaitReport(ctx, nil)
+}
 
-	// Удаляем текущий пак из очереди
-	sq.removeFromQueue(packLink)
+// WaitReport ждет выдачи доступа и вызывает report при каждом изменении места
+// в очереди или оценки ожидания. Если report вызывался, после выдачи доступа
+// он вызывается еще раз с нулевой позицией.
+func (t *Ticket) WaitReport(ctx context.Context, report func(Status)) error {
+	sq := t.queue
+	reported := false
+	var last Status
 
-	// Если очередь пустая - просто очищаем текущий пак
-	if len(sq.packQueue) == 0 {
-		sq.currentPack = ""
-		return
-	}
+	for {
+		sq.mu.Lock()
+		changed := sq.changed
+		status := sq.status(t)
+		sq.mu.Unlock()
 
-	// Берем следующий пак из очереди
-	nextPack := sq.packQueue[0]
-	sq.currentPack = nextPack
+		if report != nil && status.Position > 0 && (!reported || status != last) {
+			report(status)
+			reported, last = true, status
+		}
 
-	// Сигнализируем всем ожидающим этого пака
-	if channels, ok := sq.waitChannels[nextPack]; ok {
-		for _, ch := range channels {
-			close(ch)
+		select {
+		case <-t.ready:
+			if t.err == nil && reported {
+				report(Status{})
+			}
+			return t.err
+		case <-ctx.Done():
+			t.cancel()
+			return ctx.Err()
+		case <-changed:
 		}
-		delete(sq.waitChannels, nextPack)
 	}
 }
 
-// Clear очищает все очереди
-func (sq *StickerQueue) Clear() {
+func (t *Ticket) cancel() {
+	sq := t.queue
 	sq.mu.Lock()
-	defer sq.mu.Unlock()
+	if t.granted {
+		sq.mu.Unlock()
+		t.Release()
+		return
+	}
+	if t.err == nil {
+		sq.remove(t)
+		sq.dispatch()
+		sq.notify()
+	}
+	sq.mu.Unlock()
+}
 
-	// Закрываем все каналы ожидания
-	for _, channels := range sq.waitChannels {
-		for _, ch := range channels {
-			close(ch)
+// Release освобождает пак и пропускает следующих в очереди. Повторный вызов ничего не делает.
+func (t *Ticket) Release() {
+	t.once.Do(func() {
+		sq := t.queue
+		sq.mu.Lock()
+		defer sq.mu.Unlock()
+
+		if !t.granted {
+			return
+		}
+		if sq.active[t.pack] == t {
+			delete(sq.active, t.pack)
+		}
+		sq.record(time.Since(t.since))
+		sq.dispatch()
+		sq.notify()
+	})
+}
+
+// dispatch выдает доступ ожидающим по порядку. Запрос к занятому паку
+// пропускается, запросы к свободным пакам идут дальше, пока не исчерпан лимит.
+// Вызывается под sq.mu.
+func (sq *StickerQueue) dispatch() {
+	for i := 0; i < len(sq.waiters) && len(sq.active) < sq.limit; {
+		t := sq.waiters[i]
+		if _, busy := sq.active[t.pack]; busy {
+			i++
+			continue
 		}
-	}
 
-	sq.currentPack = ""
-	sq.packQueue = nil
-	sq.waitChannels = make(map[string][]chan struct{})
+		sq.active[t.pack] = t
+		t.granted = true
+		t.since = time.Now()
+		close(t.ready)
+		sq.waiters = append(sq.waiters[:i], sq.waiters[i+1:]...)
+	}
 }
 
-func (sq *StickerQueue) isInQueue(packLink string) bool {
-	for _, p := range sq.packQueue {
-		if p == packLink {
-			return true
+func (sq *StickerQueue) remove(t *Ticket) {
+	for i, w := range sq.waiters {
+		if w == t {
+			sq.waiters = append(sq.waiters[:i], sq.waiters[i+1:]...)
+			return
 		}
 	}
-	return false
 }
 
-func (sq *StickerQueue) removeFromQueue(packLink string) {
-	newQueue := make([]string, 0, len(sq.packQueue))
-	for _, p := range sq.packQueue {
-		if p != packLink {
-			newQueue = append(newQueue, p)
+func (sq *StickerQueue) position(t *Ticket) int {
+	for i, w := range sq.waiters {
+		if w == t {
+			return i + 1
 		}
 	}
-	sq.packQueue = newQueue
+	return 0
+}
+
+// status оценивает ожидание как среднее время обработки на каждую волну из limit
+// паков впереди. Вызывается под sq.mu.
+func (sq *StickerQueue) status(t *Ticket) Status {
+	position := sq.position(t)
+	if position == 0 || len(sq.history) == 0 {
+		return Status{Position: position}
+	}
+
+	var total time.Duration
+	for _, d := range sq.history {
+		total += d
+	}
+	avg := total / time.Duration(len(sq.history))
+	waves := (position + sq.limit - 1) / sq.limit
+
+	return Status{
+		Position: position,
+		ETA:      (avg * time.Duration(waves)).Round(time.Second),
+	}
+}
+
+// record запоминает длительность обработки. Вызывается под sq.mu.
+func (sq *StickerQueue) record(d time.Duration) {
+	sq.history = append(sq.history, d)
+	if len(sq.history) > historySize {
+		sq.history = sq.history[len(sq.history)-historySize:]
+	}
+}
+
+// notify будит всех, кто следит за движением очереди. Вызывается под sq.mu.
+func (sq *StickerQueue) notify() {
+	close(sq.changed)
+	sq.changed = make(chan struct{})
 }
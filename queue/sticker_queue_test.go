@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func granted(t *Ticket) bool {
+	select {
+	case <-t.ready:
+		return t.err == nil
+	default:
+		return false
+	}
+}
+
+func TestStickerQueue_ParallelPacks(t *testing.T) {
+	sq := New(2)
+
+	a := sq.Join("a")
+	b := sq.Join("b")
+	c := sq.Join("c")
+
+	assert.True(t, granted(a))
+	assert.True(t, granted(b))
+	assert.False(t, granted(c), "лимит паков исчерпан")
+	assert.Equal(t, 1, c.Position())
+
+	b.Release()
+	assert.True(t, granted(c))
+	assert.Equal(t, 0, c.Position())
+}
+
+func TestStickerQueue_SamePackOrdered(t *testing.T) {
+	sq := New(4)
+
+	first := sq.Join("a")
+	second := sq.Join("a")
+	other := sq.Join("b")
+	third := sq.Join("a")
+
+	assert.True(t, granted(first))
+	assert.False(t, granted(second))
+	assert.True(t, granted(other), "другой пак не ждет занятый")
+	assert.Equal(t, 1, second.Position())
+	assert.Equal(t, 2, third.Position())
+
+	first.Release()
+	first.Release()
+	assert.True(t, granted(second))
+	assert.False(t, granted(third))
+
+	second.Release()
+	assert.True(t, granted(third))
+}
+
+func TestStickerQueue_WaitCanceled(t *testing.T) {
+	sq := New(1)
+	holder := sq.Join("a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := sq.Acquire(ctx, "b")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	waiting, active := sq.Len()
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 1, active)
+
+	holder.Release()
+	next, err := sq.Acquire(context.Background(), "c")
+	require.NoError(t, err)
+	next.Release()
+}
+
+func TestStickerQueue_ClearDoesNotGrant(t *testing.T) {
+	sq := New(1)
+	holder := sq.Join("a")
+	waiter := sq.Join("a")
+
+	sq.Clear()
+	require.ErrorIs(t, waiter.Wait(context.Background()), ErrQueueCleared)
+
+	// Снятый с очереди не должен получить пак после освобождения
+	waiter.Release()
+	holder.Release()
+	_, active := sq.Len()
+	assert.Equal(t, 0, active)
+}
+
+func TestStickerQueue_WaitReport(t *testing.T) {
+	sq := New(1)
+	sq.history = []time.Duration{10 * time.Second, 20 * time.Second}
+
+	holder := sq.Join("a")
+	ahead := sq.Join("b")
+	waiter := sq.Join("c")
+	assert.Equal(t, Status{Position: 2, ETA: 30 * time.Second}, waiter.Status())
+
+	reports := make(chan Status, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.WaitReport(context.Background(), func(s Status) { reports <- s })
+	}()
+
+	assert.Equal(t, 2, (<-reports).Position)
+	holder.Release()
+	assert.Equal(t, 1, (<-reports).Position)
+	ahead.Release()
+	assert.Equal(t, Status{}, <-reports, "после выдачи доступа приходит нулевая позиция")
+	require.NoError(t, <-done)
+	waiter.Release()
+}
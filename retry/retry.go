@@ -0,0 +1,143 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// Policy бюджет повторов одного вызова Telegram API
+type Policy struct {
+	Name     string        // имя вызова для логов
+	Attempts int           // сколько всего попыток, включая первую
+	Base     time.Duration // пауза перед первым повтором
+	Max      time.Duration // потолок паузы между попытками
+	MaxWait  time.Duration // суммарный бюджет ожидания, включая flood wait
+
+	// RetryIf дополнительно помечает ошибку как временную
+	RetryIf func(error) bool
+}
+
+var (
+	// Upload загрузка файла стикера
+	Upload = Policy{Name: "upload sticker", Attempts: 5, Base: time.Second, Max: 30 * time.Second, MaxWait: 5 * time.Minute}
+
+	// AddSticker добавление стикера в набор
+	AddSticker = Policy{Name: "add sticker", Attempts: 5, Base: time.Second, Max: 10 * time.Second, MaxWait: 5 * time.Minute}
+
+	// Read чтение набора и другие легкие вызовы
+	Read = Policy{Name: "read", Attempts: 3, Base: 500 * time.Millisecond, Max: 5 * time.Second, MaxWait: time.Minute}
+)
+
+// Do выполняет fn и повторяет ее при flood wait и временных ошибках, пока не
+// исчерпан бюджет попыток или ожидания. Возвращается последняя ошибка, по ней
+// вызывающий может узнать причину через errors.As.
+func Do[T any](ctx context.Context, policy Policy, fn func(ctx context.Context) (T, error)) (T, error) {
+	var waited time.Duration
+
+	for attempt := 1; ; attempt++ {
+		result, err := fn(ctx)
+		if err == nil {
+			return result, nil
+		}
+
+		wait, retryable := policy.delay(err, attempt)
+		if !retryable || attempt >= policy.Attempts || waited+wait > policy.MaxWait {
+			return result, err
+		}
+
+		slog.Debug("telegram call failed, retrying",
+			slog.String("call", policy.Name),
+			slog.Int("attempt", attempt),
+			slog.Duration("wait", wait),
+			slog.String("err", err.Error()))
+
+		if err := sleep(ctx, wait); err != nil {
+			return result, err
+		}
+		waited += wait
+	}
+}
+
+// Exec то же, что Do, для вызовов без результата
+func Exec(ctx context.Context, policy Policy, fn func(ctx context.Context) error) error {
+	_, err := Do(ctx, policy, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// delay возвращает паузу перед следующей попыткой. Flood wait ждет столько,
+// сколько попросил Telegram, остальные временные ошибки - экспоненциально с джиттером.
+func (p Policy) delay(err error, attempt int) (time.Duration, bool) {
+	var flood *bot.TooManyRequestsError
+	if errors.As(err, &flood) {
+		return time.Duration(flood.RetryAfter)*time.Second + jitter(p.Base), true
+	}
+
+	if !Temporary(err) && (p.RetryIf == nil || !p.RetryIf(err)) {
+		return 0, false
+	}
+
+	backoff := p.Base << (attempt - 1)
+	if backoff <= 0 || backoff > p.Max {
+		backoff = p.Max
+	}
+	// Половина паузы фиксирована, половина случайна, чтобы повторы разных задач расходились
+	return backoff/2 + jitter(backoff/2), true
+}
+
+// Temporary сообщает, что ошибку стоит повторить: это не отказ Telegram по
+// существу запроса, а сетевой сбой или ошибка на стороне сервера
+func Temporary(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	for _, permanent := range []error{
+		bot.ErrorBadRequest,
+		bot.ErrorForbidden,
+		bot.ErrorUnauthorized,
+		bot.ErrorNotFound,
+		bot.ErrorConflict,
+	} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+
+	var migrate *bot.MigrateError
+	return !errors.As(err, &migrate)
+}
+
+// RetryAfter возвращает, сколько Telegram просит подождать, если ошибка - flood wait
+func RetryAfter(err error) (time.Duration, bool) {
+	var flood *bot.TooManyRequestsError
+	if !errors.As(err, &flood) {
+		return 0, false
+	}
+	return time.Duration(flood.RetryAfter) * time.Second, true
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fast = Policy{Name: "test", Attempts: 3, Base: time.Millisecond, Max: 2 * time.Millisecond, MaxWait: time.Second}
+
+func TestRetry_TemporaryErrorsAreRetried(t *testing.T) {
+	calls := 0
+	result, err := Do(context.Background(), fast, func(ctx context.Context) (string, error) {
+		calls++
+		if calls < 3 {
+			return "", errors.New("error do request for method uploadStickerFile, connection reset")
+		}
+		return "ok", nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "ok", result)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetry_PermanentErrorsAreNot(t *testing.T) {
+	calls := 0
+	err := Exec(context.Background(), fast, func(ctx context.Context) error {
+		calls++
+		return fmt.Errorf("%w, %s", bot.ErrorBadRequest, "Bad Request: STICKERSET_INVALID")
+	})
+
+	require.ErrorIs(t, err, bot.ErrorBadRequest)
+	assert.Equal(t, 1, calls)
+
+	// RetryIf делает такую ошибку временной для конкретного вызова
+	policy := fast
+	policy.RetryIf = func(err error) bool { return errors.Is(err, bot.ErrorBadRequest) }
+	calls = 0
+	_ = Exec(context.Background(), policy, func(ctx context.Context) error {
+		calls++
+		return fmt.Errorf("%w, %s", bot.ErrorBadRequest, "Bad Request: STICKERSET_INVALID")
+	})
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetry_FloodWaitOverBudget(t *testing.T) {
+	calls := 0
+	err := Exec(context.Background(), fast, func(ctx context.Context) error {
+		calls++
+		return &bot.TooManyRequestsError{Message: "too many requests", RetryAfter: 60}
+	})
+
+	// Ждать минуту при бюджете в секунду бессмысленно: ошибка возвращается сразу
+	assert.Equal(t, 1, calls)
+	wait, ok := RetryAfter(fmt.Errorf("upload sticker: %w", err))
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, wait)
+}
+
+func TestRetry_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := fast
+	policy.Base, policy.Max = time.Hour, time.Hour
+	policy.MaxWait = 10 * time.Hour
+
+	go cancel()
+	err := Exec(ctx, policy, func(ctx context.Context) error {
+		return errors.New("temporary")
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
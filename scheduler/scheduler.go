@@ -0,0 +1,278 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	vipWeight     = 2.0
+	regularWeight = 1.0
+
+	// agingPeriod за это время ожидания приоритет заявки удваивается,
+	// чтобы тяжелые и обычные задачи не голодали за VIP
+	agingPeriod = 10 * time.Second
+)
+
+// FFmpeg общий для всех ботов и userbot планировщик процессов ffmpeg
+var FFmpeg = New(runtime.NumCPU())
+
+// Init настраивает бюджет CPU из переменной окружения FFMPEG_CPU_BUDGET
+func Init() error {
+	raw := os.Getenv("FFMPEG_CPU_BUDGET")
+	if raw == "" {
+		return nil
+	}
+
+	budget, err := strconv.Atoi(raw)
+	if err != nil || budget <= 0 {
+		return fmt.Errorf("invalid FFMPEG_CPU_BUDGET %q", raw)
+	}
+
+	FFmpeg.SetCapacity(budget)
+	return nil
+}
+
+// Request заявка на запуск процесса
+type Request struct {
+	UserID int64
+	Vip    bool
+	Cost   int // сколько слотов CPU занимает процесс
+}
+
+// Stats состояние планировщика
+type Stats struct {
+	Capacity int
+	Running  int   // занятые слоты
+	Queued   int   // заявки в очереди
+	Acquired int64 // сколько заявок получили слоты с запуска
+	AvgWait  time.Duration
+	MaxWait  time.Duration
+	// OldestWait сколько уже ждет самая старая заявка в очереди
+	OldestWait time.Duration
+}
+
+type waiter struct {
+	req     Request
+	enqueue time.Time
+	ready   chan struct{}
+	granted bool
+}
+
+// Scheduler ограничивает суммарную стоимость одновременно запущенных процессов.
+// Следующей запускается заявка с наибольшим приоритетом: VIP весит больше,
+// а пользователи, у которых уже запущены процессы, уступают остальным.
+type Scheduler struct {
+	mu       sync.Mutex
+	capacity int
+	used     int
+	waiters  []*waiter
+	inflight map[int64]int // занятые слоты по пользователям
+
+	waitTotal time.Duration
+	waitCount int64
+	waitMax   time.Duration
+}
+
+func New(capacity int) *Scheduler {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &Scheduler{
+		capacity: capacity,
+		inflight: make(map[int64]int),
+	}
+}
+
+// SetCapacity меняет бюджет CPU, ожидающие заявки пересматриваются сразу
+func (s *Scheduler) SetCapacity(capacity int) {
+	if capacity <= 0 {
+		capacity = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity = capacity
+	s.dispatch()
+}
+
+// Acquire ждет свободных слотов для заявки и возвращает функцию их освобождения.
+// Если ctx отменен раньше, заявка снимается с очереди.
+func (s *Scheduler) Acquire(ctx context.Context, req Request) (func(), error) {
+	s.mu.Lock()
+	if req.Cost <= 0 {
+		req.Cost = 1
+	}
+	if req.Cost > s.capacity {
+		req.Cost = s.capacity
+	}
+
+	w := &waiter{
+		req:     req,
+		enqueue: time.Now(),
+		ready:   make(chan struct{}),
+	}
+	s.waiters = append(s.waiters, w)
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.granted {
+			s.mu.Unlock()
+			s.release(req)
+		} else {
+			s.remove(w)
+			s.dispatch()
+			s.mu.Unlock()
+		}
+		return nil, ctx.Err()
+	}
+
+	wait := time.Since(w.enqueue)
+	stats := s.recordWait(wait)
+	if wait > time.Second {
+		slog.Debug("ffmpeg slot acquired",
+			slog.Int64("user_id", req.UserID),
+			slog.Bool("vip", req.Vip),
+			slog.Int("cost", req.Cost),
+			slog.Duration("wait", wait),
+			slog.Int("queued", stats.Queued),
+			slog.Int("running", stats.Running))
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.release(req) })
+	}, nil
+}
+
+// Stats возвращает глубину очереди и статистику ожидания
+func (s *Scheduler) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats()
+}
+
+func (s *Scheduler) stats() Stats {
+	st := Stats{
+		Capacity: s.capacity,
+		Running:  s.used,
+		Queued:   len(s.waiters),
+		Acquired: s.waitCount,
+		MaxWait:  s.waitMax,
+	}
+	if s.waitCount > 0 {
+		st.AvgWait = s.waitTotal / time.Duration(s.waitCount)
+	}
+	for _, w := range s.waiters {
+		st.OldestWait = max(st.OldestWait, time.Since(w.enqueue))
+	}
+	return st
+}
+
+// ReportStats раз в interval пишет состояние очереди в лог, пока не отменен ctx.
+// Простаивающий планировщик не логируется.
+func (s *Scheduler) ReportStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastAcquired int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		st := s.Stats()
+		if st.Running == 0 && st.Queued == 0 && st.Acquired == lastAcquired {
+			continue
+		}
+		slog.Info("ffmpeg scheduler stats",
+			slog.Int("capacity", st.Capacity),
+			slog.Int("running", st.Running),
+			slog.Int("queued", st.Queued),
+			slog.Int64("acquired", st.Acquired-lastAcquired),
+			slog.Duration("avg_wait", st.AvgWait),
+			slog.Duration("max_wait", st.MaxWait),
+			slog.Duration("oldest_wait", st.OldestWait))
+		lastAcquired = st.Acquired
+	}
+}
+
+func (s *Scheduler) recordWait(wait time.Duration) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waitTotal += wait
+	s.waitCount++
+	if wait > s.waitMax {
+		s.waitMax = wait
+	}
+	return s.stats()
+}
+
+func (s *Scheduler) release(req Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.used -= req.Cost
+	s.inflight[req.UserID] -= req.Cost
+	if s.inflight[req.UserID] <= 0 {
+		delete(s.inflight, req.UserID)
+	}
+	s.dispatch()
+}
+
+// dispatch запускает заявки по приоритету, пока хватает слотов.
+// Вызывается под s.mu.
+func (s *Scheduler) dispatch() {
+	now := time.Now()
+	for len(s.waiters) > 0 {
+		best := 0
+		bestPriority := s.priority(s.waiters[0], now)
+		for i := 1; i < len(s.waiters); i++ {
+			if p := s.priority(s.waiters[i], now); p > bestPriority {
+				best, bestPriority = i, p
+			}
+		}
+
+		// Не пропускаем вперед дешевые заявки, иначе дорогая может ждать вечно
+		w := s.waiters[best]
+		if s.used+w.req.Cost > s.capacity {
+			return
+		}
+
+		s.used += w.req.Cost
+		s.inflight[w.req.UserID] += w.req.Cost
+		w.granted = true
+		close(w.ready)
+		s.remove(w)
+	}
+}
+
+func (s *Scheduler) priority(w *waiter, now time.Time) float64 {
+	weight := regularWeight
+	if w.req.Vip {
+		weight = vipWeight
+	}
+	aging := 1 + float64(now.Sub(w.enqueue))/float64(agingPeriod)
+	return weight * aging / float64(1+s.inflight[w.req.UserID])
+}
+
+func (s *Scheduler) remove(w *waiter) {
+	for i, candidate := range s.waiters {
+		if candidate == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acquireAsync ставит заявку в очередь и сообщает в order, когда она получила слоты
+func acquireAsync(t *testing.T, s *Scheduler, req Request, name string, order chan<- string) {
+	t.Helper()
+	go func() {
+		release, err := s.Acquire(context.Background(), req)
+		if err != nil {
+			return
+		}
+		order <- name
+		release()
+	}()
+}
+
+func waitQueued(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return s.Stats().Queued == n }, time.Second, time.Millisecond)
+}
+
+func TestScheduler_Capacity(t *testing.T) {
+	s := New(2)
+
+	// Стоимость больше бюджета урезается до него, чтобы заявка вообще могла запуститься
+	big, err := s.Acquire(context.Background(), Request{UserID: 2, Cost: 5})
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Stats().Running)
+
+	order := make(chan string, 1)
+	acquireAsync(t, s, Request{UserID: 1, Cost: 1}, "small", order)
+	waitQueued(t, s, 1)
+
+	big()
+	big()
+	assert.Equal(t, "small", <-order)
+	require.Eventually(t, func() bool { return s.Stats().Running == 0 }, time.Second, time.Millisecond)
+}
+
+func TestScheduler_PriorityAndFairShare(t *testing.T) {
+	s := New(2)
+	busy, err := s.Acquire(context.Background(), Request{UserID: 1, Cost: 1})
+	require.NoError(t, err)
+	hold, err := s.Acquire(context.Background(), Request{UserID: 9, Cost: 1})
+	require.NoError(t, err)
+
+	order := make(chan string, 3)
+	acquireAsync(t, s, Request{UserID: 1, Cost: 1}, "busy", order)
+	waitQueued(t, s, 1)
+	acquireAsync(t, s, Request{UserID: 2, Cost: 1}, "regular", order)
+	waitQueued(t, s, 2)
+	acquireAsync(t, s, Request{UserID: 3, Vip: true, Cost: 1}, "vip", order)
+	waitQueued(t, s, 3)
+
+	// VIP идет первым, затем пользователь без запущенных процессов,
+	// хотя пользователь 1 встал в очередь раньше
+	hold()
+	assert.Equal(t, "vip", <-order)
+	assert.Equal(t, "regular", <-order)
+	busy()
+	assert.Equal(t, "busy", <-order)
+}
+
+func TestScheduler_AcquireCanceled(t *testing.T) {
+	s := New(1)
+	hold, err := s.Acquire(context.Background(), Request{UserID: 1, Cost: 1})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = s.Acquire(ctx, Request{UserID: 2, Cost: 1})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, s.Stats().Queued)
+
+	hold()
+	assert.Equal(t, 0, s.Stats().Running)
+}
+
+func TestScheduler_StatsWait(t *testing.T) {
+	s := New(1)
+	hold, err := s.Acquire(context.Background(), Request{UserID: 1, Cost: 1})
+	require.NoError(t, err)
+
+	order := make(chan string, 1)
+	acquireAsync(t, s, Request{UserID: 2, Cost: 1}, "queued", order)
+	waitQueued(t, s, 1)
+	require.Eventually(t, func() bool { return s.Stats().OldestWait > 0 }, time.Second, time.Millisecond)
+
+	time.Sleep(10 * time.Millisecond)
+	hold()
+	assert.Equal(t, "queued", <-order)
+
+	st := s.Stats()
+	assert.Equal(t, int64(2), st.Acquired)
+	assert.Equal(t, time.Duration(0), st.OldestWait)
+	assert.GreaterOrEqual(t, st.MaxWait, 10*time.Millisecond)
+}
@@ -1,14 +1,37 @@
 package types
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/go-telegram/bot/models"
 )
 
+// UploadedTile ячейка композиции, уже загруженная в Telegram. Slot - номер
+// загружаемого тайла композиции по строкам, отступы и пустые тайлы не нумеруются.
+type UploadedTile struct {
+	Slot     int    `json:"slot" db:"slot"`
+	FilePath string `json:"file_path" db:"file_path"`
+	FileID   string `json:"file_id" db:"file_id"`
+	Added    bool   `json:"added" db:"added"`
+	PackLink string `json:"pack_link" db:"pack_link"` // пак семьи, в который добавлена ячейка
+	// StickerIndex позиция стикера в PackLink, который заменяет ячейка, или -1
+	StickerIndex int `json:"sticker_index" db:"sticker_index"`
+}
+
+// UploadCheckpoint сохраняет прогресс загрузки эмодзи, чтобы повторная попытка
+// продолжила с последнего подтвержденного стикера, а не загружала дубликаты
+type UploadCheckpoint interface {
+	Load(ctx context.Context) (map[int]UploadedTile, error)
+	SaveUploaded(ctx context.Context, slot int, filePath string, fileID string) error
+	SaveAdded(ctx context.Context, packLink string, slots ...int) error
+	SaveTarget(ctx context.Context, slot int, packLink string, index int) error
+}
+
 type EmojiMeta struct {
 	FileID      string `json:"file_id"`
 	DocumentID  string `json:"document_id"`
@@ -22,11 +45,28 @@ var (
 	ErrFileOfInvalidType   = errors.New("file of invalid type")
 	ErrGetFileFromTelegram = errors.New("get file from telegram failed")
 	ErrFileDownloadFailed  = errors.New("ошибка в загрузке файла")
+	ErrJobCanceled         = errors.New("генерация отменена")
+
+	ErrInvalidFormat  = fmt.Errorf("неверный формат параметра, используйте формат param=value или param=[value]")
+	ErrUnknownParam   = fmt.Errorf("неизвестный параметр")
+	ErrInvalidWidth   = fmt.Errorf("ширина должна быть числом")
+	ErrInvalidIphone  = fmt.Errorf("параметр iphone должен быть true или false")
+	ErrInvalidQuality = fmt.Errorf("параметр q должен быть high, balanced или small")
+	ErrInvalidFit     = fmt.Errorf("параметр fit должен быть cover, contain, stretch или crop")
+	ErrInvalidAnchor  = fmt.Errorf("параметр anchor должен быть center, top или bottom")
+	ErrGridTooLarge   = fmt.Errorf("композиция не может содержать больше %d эмодзи, уменьшите w или h", MaxStickersTotal)
+
+	ErrInvalidStart    = fmt.Errorf("параметр start должен быть неотрицательным числом секунд")
+	ErrInvalidDuration = fmt.Errorf("параметр dur должен быть числом секунд от 0.1 до 3")
+	ErrInvalidFPS      = fmt.Errorf("параметр fps должен быть целым числом от 1 до 30")
+	ErrInvalidSpeed    = fmt.Errorf("параметр speed должен быть числом от 0.25 до 4")
+	ErrInvalidReverse  = fmt.Errorf("параметр reverse должен быть true или false")
+	ErrInvalidLoop     = fmt.Errorf("параметр loop должен быть boomerang или smooth")
+	ErrInvalidAuto     = fmt.Errorf("параметр auto должен быть true или false")
 
-	ErrInvalidFormat = fmt.Errorf("неверный формат параметра, используйте формат param=value или param=[value]")
-	ErrUnknownParam  = fmt.Errorf("неизвестный параметр")
-	ErrInvalidWidth  = fmt.Errorf("ширина должна быть числом")
-	ErrInvalidIphone = fmt.Errorf("параметр iphone должен быть true или false")
+	ErrTileTooBig     = errors.New("не удалось уменьшить размер эмодзи до лимита Telegram")
+	ErrTilesNotValid  = errors.New("эмодзи не соответствуют требованиям Telegram")
+	ErrNoVisibleTiles = errors.New("в композиции нет ни одного видимого эмодзи, проверьте параметры удаления фона")
 
 	ErrInvalidBackgroundArgumentsUse = fmt.Errorf("b_sim и b_blend являются дополнительными параметрами к удалению цвета указанного в background. Используйте эти парамтеры в связке")
 )
@@ -35,6 +75,31 @@ var (
 	PackTitleTempl = " ⁂ @drip_tech"
 )
 
+const (
+	QualityHigh     = "high"
+	QualityBalanced = "balanced"
+	QualitySmall    = "small"
+
+	DefaultQuality = QualityBalanced
+)
+
+// Как видео вписывается в сетку, параметр fit
+const (
+	FitCover   = "cover"   // заполнить сетку, лишнее обрезать
+	FitContain = "contain" // вписать целиком, остаток сетки прозрачный
+	FitStretch = "stretch" // растянуть по сетке без сохранения пропорций
+	FitCrop    = "crop"    // вырезать кусок сетки без масштабирования
+
+	DefaultFit = FitContain
+)
+
+// Какая часть видео сохраняется при обрезке и куда прижимается при вписывании, параметр anchor
+const (
+	AnchorCenter = "center"
+	AnchorTop    = "top"
+	AnchorBottom = "bottom"
+)
+
 const (
 	TelegramPackLinkAndNameLength = 64
 	DefaultWidth                  = 8
@@ -42,6 +107,23 @@ const (
 	MaxStickersInBatch  = 50
 	MaxStickersTotal    = 200
 	MaxStickerInMessage = 100
+
+	// MaxEmojiFileSize лимит Telegram на размер видео кастомного эмодзи
+	MaxEmojiFileSize = 256 * 1024
+	EmojiSize        = 100
+	MaxEmojiDuration = 3.0
+	MaxEmojiFPS      = 30
+
+	MinEmojiDuration = 0.1
+	DefaultEmojiFPS  = 10
+	MinSpeed         = 0.25
+	MaxSpeed         = 4.0
+)
+
+// Как эмодзи зацикливается, параметр loop
+const (
+	LoopBoomerang = "boomerang" // вперед и обратно
+	LoopSmooth    = "smooth"    // конец плавно перетекает в начало
 )
 
 var (
@@ -59,17 +141,31 @@ var (
 type EmojiCommand struct {
 	UserName string `json:"user_name"`
 
-	SetName         string       `json:"set_name"`
-	PackLink        string       `json:"pack_link"`
-	Width           int          `json:"width"`
+	SetName  string `json:"set_name"`
+	PackLink string `json:"pack_link"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"` // число рядов, 0 - по пропорциям видео
+	Fit      string `json:"fit"`
+	Anchor   string `json:"anchor"` // пусто - по умолчанию для режима fit
+
+	// Отрезок исходного видео и как он проигрывается в эмодзи. Нулевые значения -
+	// значения по умолчанию: с начала, MaxEmojiDuration, DefaultEmojiFPS, скорость 1.
+	Start           float64      `json:"start"`
+	Duration        float64      `json:"duration"` // длительность эмодзи в секундах
+	FPS             int          `json:"fps"`
+	Speed           float64      `json:"speed"`
+	Reverse         bool         `json:"reverse"`
+	Loop            string       `json:"loop"`
+	Auto            bool         `json:"auto"` // Start выбирается анализом видео, см. processing.SelectSegment
 	BackgroundColor string       `json:"background_color"`
 	BackgroundBlend string       `json:"background_blend"`
 	BackgroundSim   string       `json:"background_sim"`
 	UserID          int64        `json:"user_id"`
 	DownloadedFile  string       `json:"downloaded_file"`
 	File            *models.File `json:"file"`
+	MimeType        string       `json:"mime_type"`
 
-	QualityValue int `json:"quality_value"`
+	Quality string `json:"quality"`
 
 	RawInitCommand string `json:"raw_init_command"`
 	Iphone         bool   `json:"iphone"`
@@ -78,13 +174,60 @@ type EmojiCommand struct {
 
 	NewSet      bool        `json:"new_set"`
 	Permissions Permissions `json:"permissions"`
+
+	// Composition композиция, которую перегенерирует /redo
+	Composition int64 `json:"composition,omitempty"`
+
+	// QueueProgress вызывается, пока пак ждет очереди на загрузку: с местом в
+	// очереди и оценкой ожидания, а после выдачи доступа - с нулевой позицией
+	QueueProgress func(position int, eta time.Duration) `json:"-"`
+	// Checkpoint если задан, загрузка эмодзи продолжается с сохраненного места
+	Checkpoint UploadCheckpoint `json:"-"`
 }
 
 func (e *EmojiCommand) SetDefault() {
 	e.Width = DefaultWidth
+	e.Quality = DefaultQuality
+	e.Fit = DefaultFit
 	e.NewSet = true
 }
 
+// FrameAnchor возвращает привязку кадра: по умолчанию вписанное видео прижимается
+// к верху, и поля остаются в нижнем ряду, а при обрезке сохраняется центр
+func (e *EmojiCommand) FrameAnchor() string {
+	if e.Anchor != "" {
+		return e.Anchor
+	}
+	if e.Fit == FitContain || e.Fit == "" {
+		return AnchorTop
+	}
+	return AnchorCenter
+}
+
+// userbotSourcePrefix отличает источник из личных сообщений userbot от file ID Bot API
+const userbotSourcePrefix = "userbot:"
+
+// SetUserbotSource запоминает исходный файл команды из личных сообщений userbot.
+// Бот не может скачать документ MTProto, а ссылка на файл в документе устаревает,
+// поэтому источником считается сообщение msgID в чате chatID: по нему userbot
+// скачивает файл заново, например для /redo.
+func (e *EmojiCommand) SetUserbotSource(chatID int64, msgID int, mimeType string) {
+	e.File = &models.File{FileID: fmt.Sprintf("%s%d:%d", userbotSourcePrefix, chatID, msgID)}
+	e.MimeType = mimeType
+}
+
+// ParseUserbotSource разбирает источник, записанный SetUserbotSource
+func ParseUserbotSource(fileID string) (chatID int64, msgID int, ok bool) {
+	rest, found := strings.CutPrefix(fileID, userbotSourcePrefix)
+	if !found {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscanf(rest, "%d:%d", &chatID, &msgID); err != nil {
+		return 0, 0, false
+	}
+	return chatID, msgID, true
+}
+
 func (e *EmojiCommand) ToSlogAttributes(attrs ...slog.Attr) []slog.Attr {
 	a := []slog.Attr{
 		slog.Int64("user_id", e.UserID),
@@ -92,11 +235,22 @@ func (e *EmojiCommand) ToSlogAttributes(attrs ...slog.Attr) []slog.Attr {
 		slog.String("name", e.SetName),
 		slog.String("pack_link", e.PackLink),
 		slog.Int("width", e.Width),
+		slog.Int("height", e.Height),
+		slog.String("fit", e.Fit),
+		slog.String("anchor", e.Anchor),
+		slog.Float64("start", e.Start),
+		slog.Float64("duration", e.Duration),
+		slog.Int("fps", e.FPS),
+		slog.Float64("speed", e.Speed),
+		slog.Bool("reverse", e.Reverse),
+		slog.String("loop", e.Loop),
+		slog.Bool("auto", e.Auto),
 		slog.String("background", e.BackgroundColor),
 		slog.String("file", e.DownloadedFile),
 		slog.String("file_path", e.File.FilePath),
 		slog.String("file_id", e.File.FileID),
 		slog.Bool("iphone", e.Iphone),
+		slog.String("quality", e.Quality),
 	}
 
 	a = append(a, attrs...)
@@ -111,6 +265,44 @@ var ArgAlias = map[string]string{
 	"ширина": "width",
 	"ш":      "width",
 
+	// height aliases
+	"height": "height",
+	"h":      "height",
+	"высота": "height",
+	"в":      "height",
+
+	// fit aliases
+	"fit":   "fit",
+	"режим": "fit",
+
+	// anchor aliases
+	"anchor": "anchor",
+	"якорь":  "anchor",
+
+	// time aliases
+	"start":  "start",
+	"ss":     "start",
+	"начало": "start",
+
+	"dur":          "duration",
+	"duration":     "duration",
+	"длительность": "duration",
+
+	"fps":   "fps",
+	"кадры": "fps",
+
+	"speed":    "speed",
+	"скорость": "speed",
+
+	"reverse": "reverse",
+	"реверс":  "reverse",
+
+	"loop":  "loop",
+	"петля": "loop",
+
+	"auto": "auto",
+	"авто": "auto",
+
 	// name aliases
 	"name": "name",
 	"n":    "name",
@@ -145,6 +337,12 @@ var ArgAlias = map[string]string{
 	"ip":     "iphone",
 	"айфон":  "iphone",
 	"а":      "iphone",
+
+	// quality aliases
+	"quality":  "quality",
+	"q":        "quality",
+	"качество": "quality",
+	"к":        "quality",
 }
 
 var ColorMap = map[string]string{